@@ -0,0 +1,48 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	BackupConditionPending   string = "Pending"
+	BackupConditionRunning   string = "Running"
+	BackupConditionCompleted string = "Completed"
+	BackupConditionFailed    string = "Failed"
+	BackupConditionError     string = "Errored"
+)
+
+const (
+	BackupConditionReasonPending   string = "PendingBackup"
+	BackupConditionReasonRunning   string = "RunningBackup"
+	BackupConditionReasonCompleted string = "CompletedBackup"
+	BackupConditionReasonFailed    string = "FailedBackup"
+	BackupConditionReasonError     string = "ErroredBackup"
+)
+
+func (b *Backup) PendingCondition() *metav1.Condition {
+	return &metav1.Condition{Type: BackupConditionPending, Status: metav1.ConditionTrue,
+		Reason: BackupConditionReasonPending, Message: "Backup is waiting for the database to be created"}
+}
+
+func (b *Backup) RunningCondition() *metav1.Condition {
+	return &metav1.Condition{Type: BackupConditionRunning, Status: metav1.ConditionTrue,
+		Reason: BackupConditionReasonRunning, Message: "Backup is running"}
+}
+
+func (b *Backup) CompletedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: BackupConditionCompleted, Status: metav1.ConditionTrue,
+		Reason: BackupConditionReasonCompleted, Message: "Backup successfully completed"}
+}
+
+// FailedCondition the server rejected the backup, it is not retried
+func (b *Backup) FailedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: BackupConditionFailed, Status: metav1.ConditionTrue,
+		Reason: BackupConditionReasonFailed, Message: message}
+}
+
+// ErroredCondition the backup could not be started yet, it is retried
+func (b *Backup) ErroredCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: BackupConditionError, Status: metav1.ConditionTrue,
+		Reason: BackupConditionReasonError, Message: message}
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupType kind of backup to take
+// +kubebuilder:validation:Enum=Full;Differential;Log
+type BackupType string
+
+const (
+	BackupFull         BackupType = "Full"
+	BackupDifferential BackupType = "Differential"
+	BackupLog          BackupType = "Log"
+)
+
+// BackupLocation where a backup file lives, exactly one of Disk and URL must be set
+type BackupLocation struct {
+	// Disk path of the backup file on the sql managed instance
+	Disk string `json:"disk,omitempty"`
+	// URL of the backup in blob storage, the instance needs a credential for the container
+	URL string `json:"url,omitempty"`
+}
+
+// BackupSpec defines the desired state of Backup
+type BackupSpec struct {
+	// DatabaseRef name of the Database object, in the same namespace, to back up
+	DatabaseRef string `json:"databaseRef"`
+	// Type of the backup, defaults to Full
+	// +kubebuilder:default=Full
+	Type BackupType `json:"type,omitempty"`
+	// Destination the backup is written to
+	Destination BackupLocation `json:"destination"`
+	// CopyOnly takes the backup without affecting the sequence of conventional backups
+	CopyOnly bool `json:"copyOnly,omitempty"`
+	// Compression overrides the server default for backup compression
+	Compression *bool `json:"compression,omitempty"`
+	// PruneOnDelete deletes the backup file from disk when the Backup is deleted
+	PruneOnDelete bool `json:"pruneOnDelete,omitempty"`
+}
+
+// BackupStatus defines the observed state of Backup
+type BackupStatus struct {
+	Status string `json:"status"`
+	// DatabaseID guid of the database that was backed up
+	DatabaseID string `json:"databaseID,omitempty"`
+	// SessionID of the session running the backup
+	SessionID int `json:"sessionID,omitempty"`
+	// PercentComplete progress of the running backup statement
+	PercentComplete int `json:"percentComplete,omitempty"`
+	// StartTime when the backup started on the server
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// FinishTime when the backup finished on the server
+	FinishTime *metav1.Time `json:"finishTime,omitempty"`
+	// Size of the backup set in bytes
+	Size int64 `json:"size,omitempty"`
+	// CompressedSize of the backup set in bytes
+	CompressedSize int64 `json:"compressedSize,omitempty"`
+	// FirstLSN log sequence number of the first log record in the backup set
+	FirstLSN string `json:"firstLSN,omitempty"`
+	// LastLSN log sequence number of the next log record after the backup set
+	LastLSN string `json:"lastLSN,omitempty"`
+	// Conditions the array of conditions of the object
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseRef`,description="Database that is backed up"
+//+kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.type`,description="Type of Backup"
+//+kubebuilder:printcolumn:name="Backup Status",type=string,JSONPath=`.status.status`,description="Status of Backup"
+//+kubebuilder:printcolumn:name="Progress",type=integer,JSONPath=`.status.percentComplete`,description="Percent complete of the running backup statement"
+//+kubebuilder:printcolumn:name="Finished",type="date",JSONPath=`.status.finishTime`,description="When the Backup finished"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Backup is the Schema for the backups API
+type Backup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupSpec   `json:"spec,omitempty"`
+	Status BackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BackupList contains a list of Backup
+type BackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Backup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Backup{}, &BackupList{})
+}
@@ -0,0 +1,25 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	BackupPolicyConditionScheduled string = "Scheduled"
+	BackupPolicyConditionError     string = "Errored"
+)
+
+const (
+	BackupPolicyConditionReasonScheduled string = "ScheduledBackups"
+	BackupPolicyConditionReasonError     string = "ErroredBackupPolicy"
+)
+
+func (p *BackupPolicy) ScheduledCondition() *metav1.Condition {
+	return &metav1.Condition{Type: BackupPolicyConditionScheduled, Status: metav1.ConditionTrue,
+		Reason: BackupPolicyConditionReasonScheduled, Message: "Backups are scheduled"}
+}
+
+func (p *BackupPolicy) ErroredCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: BackupPolicyConditionError, Status: metav1.ConditionTrue,
+		Reason: BackupPolicyConditionReasonError, Message: message}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BackupSchedule when a type of backup is taken and how many are kept
+type BackupSchedule struct {
+	// Schedule in cron format
+	Schedule string `json:"schedule"`
+	// Retention number of completed backups of this type kept per database, older ones are deleted along with their file
+	// and the backups depending on them. Defaults to 7 for a disk destination, cannot be set for a url destination whose
+	// backups are never pruned
+	// +kubebuilder:validation:Minimum=1
+	Retention int `json:"retention,omitempty"`
+}
+
+// BackupPolicySpec defines the desired state of BackupPolicy
+type BackupPolicySpec struct {
+	// Selector of the Database objects, in the same namespace, the policy backs up
+	Selector metav1.LabelSelector `json:"selector"`
+	// Destination disk directory or url container the backup files are written to
+	Destination BackupLocation `json:"destination"`
+	// Compression overrides the server default for backup compression
+	Compression *bool `json:"compression,omitempty"`
+	// Full schedule of full database backups
+	Full *BackupSchedule `json:"full,omitempty"`
+	// Differential schedule of differential database backups
+	Differential *BackupSchedule `json:"differential,omitempty"`
+	// Log schedule of transaction log backups
+	Log *BackupSchedule `json:"log,omitempty"`
+}
+
+// BackupScheduleStatus the observed state of one of the schedules of a policy
+type BackupScheduleStatus struct {
+	// Type of the backups taken by the schedule
+	Type BackupType `json:"type"`
+	// LastScheduleTime when backups were last scheduled
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// NextScheduleTime when backups are scheduled next
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+}
+
+// BackupPolicyStatus defines the observed state of BackupPolicy
+type BackupPolicyStatus struct {
+	Status string `json:"status"`
+	// Databases names of the Database objects selected by the policy
+	Databases []string `json:"databases,omitempty"`
+	// Schedules the state of each configured schedule
+	Schedules []BackupScheduleStatus `json:"schedules,omitempty"`
+	// Conditions the array of conditions of the object
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Policy Status",type=string,JSONPath=`.status.status`,description="Status of BackupPolicy"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// BackupPolicy is the Schema for the backuppolicies API
+type BackupPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BackupPolicySpec   `json:"spec,omitempty"`
+	Status BackupPolicyStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// BackupPolicyList contains a list of BackupPolicy
+type BackupPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BackupPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&BackupPolicy{}, &BackupPolicyList{})
+}
@@ -12,16 +12,34 @@ const (
 	DatabaseConditionError    string = "Errored"
 	DatabaseConditionUpdating string = "Updating"
 	DatabaseConditionUpdated  string = "Updated"
+	DatabaseConditionAdopted  string = "Adopted"
+	DatabaseConditionBlocked  string = "DeletionBlocked"
+	// DatabaseConditionInstanceNotReady the sql managed instance does not exist or is not ready
+	DatabaseConditionInstanceNotReady string = "InstanceNotReady"
+	// DatabaseConditionCredentialsUnavailable the login the database is provisioned with cannot be read
+	DatabaseConditionCredentialsUnavailable string = "CredentialsUnavailable"
+	// DatabaseConditionDrifted whether the last sync found the database different from its spec
+	DatabaseConditionDrifted string = "Drifted"
 )
 
 const (
-	DatabaseConditionReasonPending  string = "PendingDatabase"
-	DatabaseConditionReasonCreating string = "CreatingDatabase"
-	DatabaseConditionReasonCreated  string = "CreatedDatabase"
-	DatabaseConditionReasonSynced   string = "SyncedDatabase"
-	DatabaseConditionReasonError    string = "ErroredDatabase"
-	DatabaseConditionReasonUpdating string = "UpdatingDatabase"
-	DatabaseConditionReasonUpdated  string = "UpdatedDatabase"
+	DatabaseConditionReasonPending      string = "PendingDatabase"
+	DatabaseConditionReasonCreating     string = "CreatingDatabase"
+	DatabaseConditionReasonCreated      string = "CreatedDatabase"
+	DatabaseConditionReasonSynced       string = "SyncedDatabase"
+	DatabaseConditionReasonError        string = "ErroredDatabase"
+	DatabaseConditionReasonUpdating     string = "UpdatingDatabase"
+	DatabaseConditionReasonUpdated      string = "UpdatedDatabase"
+	DatabaseConditionReasonAdopted      string = "AdoptedDatabase"
+	DatabaseConditionReasonRefused      string = "AdoptionRefused"
+	DatabaseConditionReasonSessions     string = "ActiveSessions"
+	DatabaseConditionReasonInstance     string = "WaitingForInstance"
+	DatabaseConditionReasonCredentials  string = "MissingCredentials"
+	DatabaseConditionReasonCorrected    string = "DriftCorrected"
+	DatabaseConditionReasonInSync       string = "InSync"
+	DatabaseConditionReasonDetected     string = "DriftDetected"
+	DatabaseConditionReasonAdoptedDrift string = "DriftAdopted"
+	DatabaseConditionReasonSyncRefused  string = "SyncJobRefused"
 )
 
 func (d *Database) PendingCondition() *metav1.Condition {
@@ -58,3 +76,62 @@ func (d *Database) UpdatedCondition() *metav1.Condition {
 	return &metav1.Condition{Type: DatabaseConditionUpdated, Status: metav1.ConditionTrue,
 		Reason: DatabaseConditionReasonUpdated, Message: "Database successfully updated"}
 }
+
+func (d *Database) AdoptedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionAdopted, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonAdopted, Message: "Existing database successfully adopted"}
+}
+
+// AdoptionRefusedCondition the database exists on the instance and the adoption policy does not allow managing it
+func (d *Database) AdoptionRefusedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionError, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonRefused, Message: message}
+}
+
+// SyncJobRefusedCondition the CronJob sync mode cannot run for the database
+func (d *Database) SyncJobRefusedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionError, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonSyncRefused, Message: message}
+}
+
+// DeletionBlockedCondition sessions connected to the database keep it from being dropped
+func (d *Database) DeletionBlockedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionBlocked, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonSessions, Message: message}
+}
+
+// InstanceNotReadyCondition the database waits for its sql managed instance
+func (d *Database) InstanceNotReadyCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionInstanceNotReady, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonInstance, Message: message}
+}
+
+// CredentialsUnavailableCondition the login the database is provisioned with cannot be read
+func (d *Database) CredentialsUnavailableCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionCredentialsUnavailable, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonCredentials, Message: message}
+}
+
+// DriftedCondition the last sync found the settings in message different from the spec
+func (d *Database) DriftedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionDrifted, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonCorrected, Message: message}
+}
+
+// InSyncCondition the last sync found the database matching its spec
+func (d *Database) InSyncCondition() *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionDrifted, Status: metav1.ConditionFalse,
+		Reason: DatabaseConditionReasonInSync, Message: "Database matches its spec"}
+}
+
+// DriftDetectedCondition the sync job found the settings in message different from the spec
+func (d *Database) DriftDetectedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionDrifted, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonDetected, Message: message}
+}
+
+// DriftAdoptedCondition the settings in message were found different on the instance and written into the spec
+func (d *Database) DriftAdoptedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseConditionDrifted, Status: metav1.ConditionTrue,
+		Reason: DatabaseConditionReasonAdoptedDrift, Message: message}
+}
@@ -25,12 +25,68 @@ import (
 
 // CredentialsSecret is the credentials of the secret to use for the sql server login
 type CredentialsSecret struct {
-	// Name is the Database name.
-	Name        string `json:"name"`
-	PasswordKey string `json:"passwordKey"`
-	UsernameKey string `json:"usernameKey"`
+	// Name is the name of the secret in the namespace of the Database.
+	Name string `json:"name"`
+	// PasswordKey is the key of the password in the secret.
+	// +kubebuilder:default=password
+	PasswordKey string `json:"passwordKey,omitempty"`
+	// UsernameKey is the key of the login name in the secret.
+	// +kubebuilder:default=username
+	UsernameKey string `json:"usernameKey,omitempty"`
 }
 
+// AdoptionPolicy what the controller does when the database already exists on the instance
+// +kubebuilder:validation:Enum=Fail;Adopt;AdoptIfEmpty
+type AdoptionPolicy string
+
+const (
+	// AdoptionFail refuses to manage an existing database
+	AdoptionFail AdoptionPolicy = "Fail"
+	// AdoptionAdopt manages an existing database
+	AdoptionAdopt AdoptionPolicy = "Adopt"
+	// AdoptionAdoptIfEmpty manages an existing database only when it holds no user objects
+	AdoptionAdoptIfEmpty AdoptionPolicy = "AdoptIfEmpty"
+)
+
+// SyncMode how the database is checked for drift from its spec on the schedule
+// +kubebuilder:validation:Enum=Controller;CronJob
+type SyncMode string
+
+const (
+	// SyncModeController the controller reconciles the database on the schedule
+	SyncModeController SyncMode = "Controller"
+	// SyncModeCronJob a CronJob running the sync image checks the database on the schedule
+	SyncModeCronJob SyncMode = "CronJob"
+)
+
+// DriftPolicy what happens when the database on the instance differs from the spec
+// +kubebuilder:validation:Enum=Enforce;Report;Ignore;Adopt
+type DriftPolicy string
+
+const (
+	// DriftEnforce alters the database to match the spec
+	DriftEnforce DriftPolicy = "Enforce"
+	// DriftReport records the drift in the status and leaves the database alone
+	DriftReport DriftPolicy = "Report"
+	// DriftIgnore does not check the database for drift
+	DriftIgnore DriftPolicy = "Ignore"
+	// DriftAdopt writes the values found on the instance into the spec
+	DriftAdopt DriftPolicy = "Adopt"
+)
+
+// DeletionPolicy what the controller does with the database when the Database object is deleted
+// +kubebuilder:validation:Enum=Retain;Delete;BackupThenDelete
+type DeletionPolicy string
+
+const (
+	// DeletionRetain leaves the database on the instance
+	DeletionRetain DeletionPolicy = "Retain"
+	// DeletionDelete drops the database
+	DeletionDelete DeletionPolicy = "Delete"
+	// DeletionBackupThenDelete takes a final copy only backup and drops the database
+	DeletionBackupThenDelete DeletionPolicy = "BackupThenDelete"
+)
+
 // DatabaseSpec defines the desired state of Database
 type DatabaseSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
@@ -38,25 +94,88 @@ type DatabaseSpec struct {
 
 	// Name is the Database name.
 	Name string `json:"name"`
-	// Server is the sql server (fqdn/ip addresss)
+	// Server is the sql server (fqdn/ip addresss), defaults to the primary endpoint of the sql managed instance
 	Server string `json:"server,omitempty"`
-	// CredentialsSecret is the name of the secret to use for the sql server login credentials
-	Credentials CredentialsSecret `json:"credentials,omitempty"`
-	// Port where Sql Server is listening
+	// Credentials is the secret holding the login the controller provisions the database with, the admin login
+	// of the sql managed instance is only used without it when the operator allows it
+	Credentials *CredentialsSecret `json:"credentials,omitempty"`
+	// Port where Sql Server is listening, defaults to the port of the primary endpoint of the sql managed instance
 	Port int `json:"port,omitempty"`
 	// CollationName
+	// +kubebuilder:validation:Pattern=`^[A-Za-z0-9_]+$`
 	Collation string `json:"collation,omitempty"`
 	// AllowSnapshotIsolation
-	AllowSnapshotIsolation     bool   `json:"allowSnapshotIsolation,omitempty"`
-	AllowReadCommittedSnapshot bool   `json:"allowReadCommittedSnapshot,omitempty"`
-	Parameterization           string `json:"parameterization,omitempty"`
-	CompatibilityLevel         int    `json:"compatibilityLevel,omitempty"`
+	AllowSnapshotIsolation     bool `json:"allowSnapshotIsolation,omitempty"`
+	AllowReadCommittedSnapshot bool `json:"allowReadCommittedSnapshot,omitempty"`
+	// +kubebuilder:validation:Enum=simple;forced
+	Parameterization string `json:"parameterization,omitempty"`
+	// +kubebuilder:validation:Enum=100;110;120;130;140;150;160
+	CompatibilityLevel int `json:"compatibilityLevel,omitempty"`
 	// SQLManagedInstance name of the managed instance to create database in
 	// this is used to query for the status of the instance as well as
 	// primary endpoint and connection info
 	SQLManagedInstance string `json:"sqlManagedInstance"`
 	// Schedule how often the database to k8s state should occur in cron format
 	Schedule string `json:"schedule,omitempty"`
+	// DriftPolicy what happens when the database on the instance differs from the spec, defaults to Enforce
+	// +kubebuilder:default=Enforce
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// SyncMode whether the controller or a CronJob checks the database on the schedule, defaults to Controller
+	// +kubebuilder:default=Controller
+	SyncMode SyncMode `json:"syncMode,omitempty"`
+	// AdoptionPolicy what to do when a database with the name already exists on the instance, defaults to Fail
+	// +kubebuilder:default=Fail
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+	// DeletionPolicy what to do with the database when the object is deleted, defaults to Retain
+	// +kubebuilder:default=Retain
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// FinalBackupDestination disk directory or url container the final backup of BackupThenDelete is written to,
+	// defaults to the default backup directory of the instance
+	FinalBackupDestination BackupLocation `json:"finalBackupDestination,omitempty"`
+	// SessionGracePeriodSeconds how long a drop waits for sessions connected to the database to close before
+	// rolling back their transactions and disconnecting them, when unset the drop waits until they close
+	// +kubebuilder:validation:Minimum=0
+	SessionGracePeriodSeconds *int32 `json:"sessionGracePeriodSeconds,omitempty"`
+	// SyncJobTemplate overrides the settings of the sync CronJob the operator is configured with, only used with
+	// the CronJob sync mode
+	SyncJobTemplate *SyncJobTemplate `json:"syncJobTemplate,omitempty"`
+}
+
+// FieldDrift a setting of the database on the instance that differs from the spec
+type FieldDrift struct {
+	// Field the spec field of the setting
+	Field string `json:"field"`
+	// Expected the value in the spec
+	Expected string `json:"expected"`
+	// Actual the value on the instance
+	Actual string `json:"actual"`
+}
+
+// SettingState whether the controller managed to change a setting of the database
+// +kubebuilder:validation:Enum=Applied;Failed
+type SettingState string
+
+const (
+	// SettingApplied the setting was changed to the value in the spec
+	SettingApplied SettingState = "Applied"
+	// SettingFailed the instance or the controller refused the change
+	SettingFailed SettingState = "Failed"
+)
+
+// SettingStatus the outcome of the last change the controller made to a setting of the database
+type SettingStatus struct {
+	// Field the spec field of the setting
+	Field string `json:"field"`
+	// Value the value the setting was changed to
+	Value string `json:"value"`
+	// State whether the change was applied
+	State SettingState `json:"state"`
+	// ErrorNumber the sql server error number of a failed change, zero for a change the controller refused to send
+	ErrorNumber int32 `json:"errorNumber,omitempty"`
+	// Message why the change failed
+	Message string `json:"message,omitempty"`
+	// LastAttemptTime when the change was last tried
+	LastAttemptTime metav1.Time `json:"lastAttemptTime"`
 }
 
 // DatabaseStatus defines the observed state of Database
@@ -66,6 +185,18 @@ type DatabaseStatus struct {
 	Status string `json:"status"`
 	// DatabaseID guid of the database
 	DatabaseID string `json:"databaseID,omitempty"`
+	// LastBackupTime when the last successful backup of the database finished
+	LastBackupTime *metav1.Time `json:"lastBackupTime,omitempty"`
+	// LastSyncTime when the controller last compared the database with its spec
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Drift the settings the last sync found different from the spec and did not correct
+	Drift []FieldDrift `json:"drift,omitempty"`
+	// Settings the outcome of the last change the controller made to each setting it altered
+	Settings []SettingStatus `json:"settings,omitempty"`
+	// NextSyncTime when the controller compares the database with its spec next, in the Controller sync mode
+	NextSyncTime *metav1.Time `json:"nextSyncTime,omitempty"`
+	// CredentialsSecretVersion resource version of the secret the controller last connected with
+	CredentialsSecretVersion string `json:"credentialsSecretVersion,omitempty"`
 	// Conditions the array of conditions of the object
 	Conditions []metav1.Condition `json:"conditions,omitempty"`
 }
@@ -75,6 +206,8 @@ type DatabaseStatus struct {
 //+kubebuilder:printcolumn:name="Database ID",type="string",JSONPath=`.status.databaseID`,description="MSSql Database ID"
 //+kubebuilder:printcolumn:name="Database Name",type=string,JSONPath=`.spec.name`,description="Name of Database"
 //+kubebuilder:printcolumn:name="Database Status",type=string,JSONPath=`.status.status`,description="Status of Database"
+//+kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=`.status.lastSyncTime`,description="When the database was last compared with its spec"
+//+kubebuilder:printcolumn:name="Deletion Policy",type=string,JSONPath=`.spec.deletionPolicy`,description="What happens to the database when the object is deleted"
 //+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Database is the Schema for the databases API
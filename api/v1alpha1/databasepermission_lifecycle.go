@@ -0,0 +1,47 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DatabasePermissionConditionPending string = "Pending"
+	DatabasePermissionConditionSynced  string = "Synced"
+	DatabasePermissionConditionDrifted string = "Drifted"
+	DatabasePermissionConditionError   string = "Errored"
+)
+
+const (
+	DatabasePermissionConditionReasonPending string = "PendingPermission"
+	DatabasePermissionConditionReasonSynced  string = "SyncedPermission"
+	DatabasePermissionConditionReasonDrifted string = "DriftedPermission"
+	DatabasePermissionConditionReasonNoDrift string = "NoDriftPermission"
+	DatabasePermissionConditionReasonError   string = "ErroredPermission"
+)
+
+func (p *DatabasePermission) PendingCondition() *metav1.Condition {
+	return &metav1.Condition{Type: DatabasePermissionConditionPending, Status: metav1.ConditionTrue,
+		Reason: DatabasePermissionConditionReasonPending, Message: "Permissions are pending"}
+}
+
+func (p *DatabasePermission) SyncedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: DatabasePermissionConditionSynced, Status: metav1.ConditionTrue,
+		Reason: DatabasePermissionConditionReasonSynced, Message: "Permissions successfully synced"}
+}
+
+// DriftedCondition reports whether the database had drifted from the declared permissions on the last sync
+func (p *DatabasePermission) DriftedCondition(drift []string) *metav1.Condition {
+	if len(drift) == 0 {
+		return &metav1.Condition{Type: DatabasePermissionConditionDrifted, Status: metav1.ConditionFalse,
+			Reason: DatabasePermissionConditionReasonNoDrift, Message: "Permissions match the database"}
+	}
+	return &metav1.Condition{Type: DatabasePermissionConditionDrifted, Status: metav1.ConditionTrue,
+		Reason: DatabasePermissionConditionReasonDrifted, Message: fmt.Sprintf("%d statements were needed to converge the database", len(drift))}
+}
+
+func (p *DatabasePermission) ErroredCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabasePermissionConditionError, Status: metav1.ConditionTrue,
+		Reason: DatabasePermissionConditionReasonError, Message: message}
+}
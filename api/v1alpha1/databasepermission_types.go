@@ -0,0 +1,132 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PermissionState whether the permission is granted or denied
+// +kubebuilder:validation:Enum=Grant;Deny
+type PermissionState string
+
+const (
+	PermissionGrant PermissionState = "Grant"
+	PermissionDeny  PermissionState = "Deny"
+)
+
+// SecurableClass the kind of securable a permission applies to
+// +kubebuilder:validation:Enum=Database;Schema;Object
+type SecurableClass string
+
+const (
+	SecurableDatabase SecurableClass = "Database"
+	SecurableSchema   SecurableClass = "Schema"
+	SecurableObject   SecurableClass = "Object"
+)
+
+// DatabaseRole declares a database role and its members
+type DatabaseRole struct {
+	// Name of the role, it is created when it does not exist
+	Name string `json:"name"`
+	// Members the principals that are members of the role, a member dropped from the list is removed from the role,
+	// members added by others, such as the roles of a DatabaseUser, are left alone
+	Members []string `json:"members,omitempty"`
+}
+
+// RoleMembership a principal that is a member of a database role
+type RoleMembership struct {
+	// Role the database role
+	Role string `json:"role"`
+	// Member the principal that is a member of the role
+	Member string `json:"member"`
+}
+
+// PermissionRule grants or denies permissions to a principal on a securable
+type PermissionRule struct {
+	// Principal user or role the permissions are granted or denied to
+	Principal string `json:"principal"`
+	// State either Grant or Deny, defaults to Grant
+	// +kubebuilder:default=Grant
+	State PermissionState `json:"state,omitempty"`
+	// Permissions the permission names, e.g. SELECT, EXECUTE or VIEW DEFINITION
+	Permissions []string `json:"permissions"`
+	// SecurableClass the class of the securable, defaults to Database
+	// +kubebuilder:default=Database
+	SecurableClass SecurableClass `json:"securableClass,omitempty"`
+	// Securable the schema name, or `schema.object`, ignored for the Database class
+	Securable string `json:"securable,omitempty"`
+}
+
+// DatabasePermissionSpec defines the desired state of DatabasePermission
+type DatabasePermissionSpec struct {
+	// DatabaseRef name of the Database object, in the same namespace, the permissions apply to
+	DatabaseRef string `json:"databaseRef"`
+	// Roles the database roles and their members
+	Roles []DatabaseRole `json:"roles,omitempty"`
+	// Permissions the permissions of each principal, permissions of a listed principal
+	// that are not declared are revoked
+	Permissions []PermissionRule `json:"permissions,omitempty"`
+}
+
+// DatabasePermissionStatus defines the observed state of DatabasePermission
+type DatabasePermissionStatus struct {
+	Status string `json:"status"`
+	// DatabaseID guid of the database the permissions were applied to
+	DatabaseID string `json:"databaseID,omitempty"`
+	// Roles the roles created by the controller
+	Roles []string `json:"roles,omitempty"`
+	// Memberships the role memberships granted by the controller, only these are removed when no longer declared
+	Memberships []RoleMembership `json:"memberships,omitempty"`
+	// Principals whose permissions are managed by the controller
+	Principals []string `json:"principals,omitempty"`
+	// Drift the statements that were needed to converge the database on the last sync
+	Drift []string `json:"drift,omitempty"`
+	// LastSyncTime the last time the permissions were compared with the database
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Conditions the array of conditions of the object
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseRef`,description="Database the permissions apply to"
+//+kubebuilder:printcolumn:name="Permission Status",type=string,JSONPath=`.status.status`,description="Status of the permissions"
+//+kubebuilder:printcolumn:name="Last Sync",type="date",JSONPath=`.status.lastSyncTime`,description="Last time the permissions were synced"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// DatabasePermission is the Schema for the databasepermissions API
+type DatabasePermission struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabasePermissionSpec   `json:"spec,omitempty"`
+	Status DatabasePermissionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DatabasePermissionList contains a list of DatabasePermission
+type DatabasePermissionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DatabasePermission `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabasePermission{}, &DatabasePermissionList{})
+}
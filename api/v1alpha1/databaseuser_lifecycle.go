@@ -0,0 +1,39 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	DatabaseUserConditionPending string = "Pending"
+	DatabaseUserConditionCreated string = "Created"
+	DatabaseUserConditionSynced  string = "Synced"
+	DatabaseUserConditionError   string = "Errored"
+)
+
+const (
+	DatabaseUserConditionReasonPending string = "PendingUser"
+	DatabaseUserConditionReasonCreated string = "CreatedUser"
+	DatabaseUserConditionReasonSynced  string = "SyncedUser"
+	DatabaseUserConditionReasonError   string = "ErroredUser"
+)
+
+func (u *DatabaseUser) PendingCondition() *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseUserConditionPending, Status: metav1.ConditionTrue,
+		Reason: DatabaseUserConditionReasonPending, Message: "User is pending"}
+}
+
+func (u *DatabaseUser) CreatedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseUserConditionCreated, Status: metav1.ConditionTrue,
+		Reason: DatabaseUserConditionReasonCreated, Message: "User successfully created"}
+}
+
+func (u *DatabaseUser) SyncedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseUserConditionSynced, Status: metav1.ConditionTrue,
+		Reason: DatabaseUserConditionReasonSynced, Message: "User successfully synced"}
+}
+
+func (u *DatabaseUser) ErroredCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: DatabaseUserConditionError, Status: metav1.ConditionTrue,
+		Reason: DatabaseUserConditionReasonError, Message: message}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DatabaseUserSpec defines the desired state of DatabaseUser
+type DatabaseUserSpec struct {
+	// Name is the user name inside the database.
+	Name string `json:"name"`
+	// DatabaseRef name of the Database object, in the same namespace, to create the user in
+	DatabaseRef string `json:"databaseRef"`
+	// LoginName maps the user to an existing server login, mutually exclusive with PasswordSecret
+	LoginName string `json:"loginName,omitempty"`
+	// PasswordSecret creates a contained user authenticated with this password,
+	// mutually exclusive with LoginName
+	PasswordSecret *PasswordSecret `json:"passwordSecret,omitempty"`
+	// DefaultSchema of the user, defaults to dbo
+	DefaultSchema string `json:"defaultSchema,omitempty"`
+	// Roles database roles the user is a member of
+	Roles []string `json:"roles,omitempty"`
+}
+
+// DatabaseUserStatus defines the observed state of DatabaseUser
+type DatabaseUserStatus struct {
+	Status string `json:"status"`
+	// SID security identifier of the user
+	SID string `json:"sid,omitempty"`
+	// DatabaseID guid of the database the user was created in
+	DatabaseID string `json:"databaseID,omitempty"`
+	// Roles database roles the controller made the user a member of
+	Roles []string `json:"roles,omitempty"`
+	// PasswordSecretVersion resource version of the secret the password was last set from
+	PasswordSecretVersion string `json:"passwordSecretVersion,omitempty"`
+	// Conditions the array of conditions of the object
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="User Name",type=string,JSONPath=`.spec.name`,description="Name of User"
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseRef`,description="Database the user belongs to"
+//+kubebuilder:printcolumn:name="User Status",type=string,JSONPath=`.status.status`,description="Status of User"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// DatabaseUser is the Schema for the databaseusers API
+type DatabaseUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseUserSpec   `json:"spec,omitempty"`
+	Status DatabaseUserStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// DatabaseUserList contains a list of DatabaseUser
+type DatabaseUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []DatabaseUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&DatabaseUser{}, &DatabaseUserList{})
+}
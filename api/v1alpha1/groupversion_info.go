@@ -15,8 +15,8 @@ limitations under the License.
 */
 
 // Package v1alpha1 contains API Schema definitions for the sqlmi v1alpha1 API group
-//+kubebuilder:object:generate=true
-//+groupName=sqlmi.arc-sql-mi.microsoft.io
+// +kubebuilder:object:generate=true
+// +groupName=sqlmi.arc-sql-mi.microsoft.io
 package v1alpha1
 
 import (
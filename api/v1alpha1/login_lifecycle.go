@@ -0,0 +1,39 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	LoginConditionPending string = "Pending"
+	LoginConditionCreated string = "Created"
+	LoginConditionSynced  string = "Synced"
+	LoginConditionError   string = "Errored"
+)
+
+const (
+	LoginConditionReasonPending string = "PendingLogin"
+	LoginConditionReasonCreated string = "CreatedLogin"
+	LoginConditionReasonSynced  string = "SyncedLogin"
+	LoginConditionReasonError   string = "ErroredLogin"
+)
+
+func (l *Login) PendingCondition() *metav1.Condition {
+	return &metav1.Condition{Type: LoginConditionPending, Status: metav1.ConditionTrue,
+		Reason: LoginConditionReasonPending, Message: "Login is pending"}
+}
+
+func (l *Login) CreatedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: LoginConditionCreated, Status: metav1.ConditionTrue,
+		Reason: LoginConditionReasonCreated, Message: "Login successfully created"}
+}
+
+func (l *Login) SyncedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: LoginConditionSynced, Status: metav1.ConditionTrue,
+		Reason: LoginConditionReasonSynced, Message: "Login successfully synced"}
+}
+
+func (l *Login) ErroredCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: LoginConditionError, Status: metav1.ConditionTrue,
+		Reason: LoginConditionReasonError, Message: message}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PasswordSecret references the secret key holding a password
+type PasswordSecret struct {
+	// Name of the secret, it must live in the same namespace as the resource
+	Name string `json:"name"`
+	// PasswordKey is the key of the password in the secret, defaults to `password`
+	PasswordKey string `json:"passwordKey,omitempty"`
+}
+
+// LoginSpec defines the desired state of Login
+type LoginSpec struct {
+	// Name is the server login name.
+	Name string `json:"name"`
+	// Server is the sql server (fqdn/ip addresss), defaults to the primary endpoint of the sql managed instance
+	Server string `json:"server,omitempty"`
+	// Port where Sql Server is listening, defaults to the port of the primary endpoint of the sql managed instance
+	Port int `json:"port,omitempty"`
+	// SQLManagedInstance name of the managed instance to create the login in
+	// this is used to query for the status of the instance as well as
+	// primary endpoint and connection info
+	SQLManagedInstance string `json:"sqlManagedInstance"`
+	// PasswordSecret is the secret holding the password of the login
+	PasswordSecret PasswordSecret `json:"passwordSecret"`
+	// DefaultDatabase the login connects to, defaults to master
+	DefaultDatabase string `json:"defaultDatabase,omitempty"`
+	// CheckPolicy enforces the password policy of the instance on the login
+	CheckPolicy *bool `json:"checkPolicy,omitempty"`
+	// CheckExpiration enforces the password expiration policy on the login
+	CheckExpiration *bool `json:"checkExpiration,omitempty"`
+	// Disabled denies the login access to the instance without dropping it
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+// LoginStatus defines the observed state of Login
+type LoginStatus struct {
+	Status string `json:"status"`
+	// SID security identifier of the login
+	SID string `json:"sid,omitempty"`
+	// PasswordSecretVersion resource version of the secret the password was last set from
+	PasswordSecretVersion string `json:"passwordSecretVersion,omitempty"`
+	// Conditions the array of conditions of the object
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Login Name",type=string,JSONPath=`.spec.name`,description="Name of Login"
+//+kubebuilder:printcolumn:name="Login Status",type=string,JSONPath=`.status.status`,description="Status of Login"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Login is the Schema for the logins API
+type Login struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   LoginSpec   `json:"spec,omitempty"`
+	Status LoginStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// LoginList contains a list of Login
+type LoginList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Login `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Login{}, &LoginList{})
+}
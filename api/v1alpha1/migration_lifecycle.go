@@ -0,0 +1,39 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	MigrationConditionPending string = "Pending"
+	MigrationConditionApplied string = "Applied"
+	MigrationConditionError   string = "Errored"
+)
+
+const (
+	MigrationConditionReasonPending         string = "PendingMigration"
+	MigrationConditionReasonApplied         string = "AppliedMigration"
+	MigrationConditionReasonError           string = "ErroredMigration"
+	MigrationConditionReasonHistoryMismatch string = "HistoryMismatch"
+)
+
+func (m *Migration) PendingCondition() *metav1.Condition {
+	return &metav1.Condition{Type: MigrationConditionPending, Status: metav1.ConditionTrue,
+		Reason: MigrationConditionReasonPending, Message: "Migration is waiting for the database to be created"}
+}
+
+func (m *Migration) AppliedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: MigrationConditionApplied, Status: metav1.ConditionTrue,
+		Reason: MigrationConditionReasonApplied, Message: "Migration scripts successfully applied"}
+}
+
+func (m *Migration) ErroredCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: MigrationConditionError, Status: metav1.ConditionTrue,
+		Reason: MigrationConditionReasonError, Message: message}
+}
+
+// HistoryMismatchCondition the declared scripts disagree with the history table, they are not applied until fixed
+func (m *Migration) HistoryMismatchCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: MigrationConditionError, Status: metav1.ConditionTrue,
+		Reason: MigrationConditionReasonHistoryMismatch, Message: message}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MigrationScript a versioned t-sql script read from a ConfigMap or Secret key, exactly one of the two must be set
+type MigrationScript struct {
+	// Version unique identifier of the script recorded in the history table
+	Version string `json:"version"`
+	// ConfigMapKeyRef key of a ConfigMap, in the same namespace, holding the script
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef,omitempty"`
+	// SecretKeyRef key of a Secret, in the same namespace, holding the script
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// MigrationSpec defines the desired state of Migration
+type MigrationSpec struct {
+	// DatabaseRef name of the Database object, in the same namespace, to migrate
+	DatabaseRef string `json:"databaseRef"`
+	// Scripts applied in order, each in its own transaction
+	Scripts []MigrationScript `json:"scripts"`
+	// HistoryTable name of the table in the dbo schema recording the applied versions
+	// +kubebuilder:default=__migration_history
+	HistoryTable string `json:"historyTable,omitempty"`
+}
+
+// MigrationStatus defines the observed state of Migration
+type MigrationStatus struct {
+	Status string `json:"status"`
+	// DatabaseID guid of the database the scripts were applied to
+	DatabaseID string `json:"databaseID,omitempty"`
+	// AppliedVersions versions of the declared scripts recorded in the history table
+	AppliedVersions []string `json:"appliedVersions,omitempty"`
+	// LastAppliedVersion latest version recorded in the history table
+	LastAppliedVersion string `json:"lastAppliedVersion,omitempty"`
+	// Conditions the array of conditions of the object
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Database",type=string,JSONPath=`.spec.databaseRef`,description="Database that is migrated"
+//+kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.lastAppliedVersion`,description="Last applied version"
+//+kubebuilder:printcolumn:name="Migration Status",type=string,JSONPath=`.status.status`,description="Status of Migration"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Migration is the Schema for the migrations API
+type Migration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MigrationSpec   `json:"spec,omitempty"`
+	Status MigrationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// MigrationList contains a list of Migration
+type MigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Migration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Migration{}, &MigrationList{})
+}
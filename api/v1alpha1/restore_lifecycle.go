@@ -0,0 +1,41 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	RestoreConditionRunning   string = "Running"
+	RestoreConditionCompleted string = "Completed"
+	RestoreConditionFailed    string = "Failed"
+	RestoreConditionError     string = "Errored"
+)
+
+const (
+	RestoreConditionReasonRunning   string = "RunningRestore"
+	RestoreConditionReasonCompleted string = "CompletedRestore"
+	RestoreConditionReasonFailed    string = "FailedRestore"
+	RestoreConditionReasonError     string = "ErroredRestore"
+)
+
+func (r *Restore) RunningCondition() *metav1.Condition {
+	return &metav1.Condition{Type: RestoreConditionRunning, Status: metav1.ConditionTrue,
+		Reason: RestoreConditionReasonRunning, Message: "Restore is running"}
+}
+
+func (r *Restore) CompletedCondition() *metav1.Condition {
+	return &metav1.Condition{Type: RestoreConditionCompleted, Status: metav1.ConditionTrue,
+		Reason: RestoreConditionReasonCompleted, Message: "Restore successfully completed"}
+}
+
+// FailedCondition the restore did not succeed, it is not retried
+func (r *Restore) FailedCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: RestoreConditionFailed, Status: metav1.ConditionTrue,
+		Reason: RestoreConditionReasonFailed, Message: message}
+}
+
+// ErroredCondition the restore could not be started or tracked yet, it is retried
+func (r *Restore) ErroredCondition(message string) *metav1.Condition {
+	return &metav1.Condition{Type: RestoreConditionError, Status: metav1.ConditionTrue,
+		Reason: RestoreConditionReasonError, Message: message}
+}
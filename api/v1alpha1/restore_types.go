@@ -0,0 +1,103 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RestoreMove relocates a database file while restoring
+type RestoreMove struct {
+	// LogicalName of the file in the backup
+	LogicalName string `json:"logicalName"`
+	// PhysicalName path the file is restored to
+	PhysicalName string `json:"physicalName"`
+}
+
+// RestoreSpec defines the desired state of Restore
+type RestoreSpec struct {
+	// DatabaseName is the name of the database to restore into, it is overwritten when it exists and Replace is set
+	DatabaseName string `json:"databaseName"`
+	// DatabaseRef name of the Database object created or adopted for the restored database, defaults to the Restore name
+	DatabaseRef string `json:"databaseRef,omitempty"`
+	// Server is the sql server (fqdn/ip addresss), defaults to the primary endpoint of the sql managed instance
+	Server string `json:"server,omitempty"`
+	// Port where Sql Server is listening, defaults to the port of the primary endpoint of the sql managed instance
+	Port int `json:"port,omitempty"`
+	// Credentials is the secret holding the login the database is restored with and the Database created for it is
+	// provisioned with, defaults to the credentials of the Database named by DatabaseRef. The admin login of the sql
+	// managed instance is only used without either when the operator allows it
+	Credentials *CredentialsSecret `json:"credentials,omitempty"`
+	// SQLManagedInstance name of the managed instance to restore the database on
+	SQLManagedInstance string `json:"sqlManagedInstance"`
+	// Source full database backup to restore
+	Source BackupLocation `json:"source"`
+	// Logs transaction log backups applied in order after the Source
+	Logs []BackupLocation `json:"logs,omitempty"`
+	// Move relocates database files, needed when the files of the backup are in use by another database
+	Move []RestoreMove `json:"move,omitempty"`
+	// Replace overwrites an existing database
+	Replace bool `json:"replace,omitempty"`
+	// StopAt recovers the database to this point in time
+	StopAt *metav1.Time `json:"stopAt,omitempty"`
+}
+
+// RestoreStatus defines the observed state of Restore
+type RestoreStatus struct {
+	Status string `json:"status"`
+	// SessionID of the session running the restore
+	SessionID int `json:"sessionID,omitempty"`
+	// PercentComplete progress of the running restore statement
+	PercentComplete int `json:"percentComplete,omitempty"`
+	// StartTime when the restore started
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime when the restore finished
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// DatabaseID guid of the restored database
+	DatabaseID string `json:"databaseID,omitempty"`
+	// Conditions the array of conditions of the object
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Database Name",type=string,JSONPath=`.spec.databaseName`,description="Database restored into"
+//+kubebuilder:printcolumn:name="Restore Status",type=string,JSONPath=`.status.status`,description="Status of Restore"
+//+kubebuilder:printcolumn:name="Progress",type=integer,JSONPath=`.status.percentComplete`,description="Percent complete of the running restore statement"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Restore is the Schema for the restores API
+type Restore struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RestoreSpec   `json:"spec,omitempty"`
+	Status RestoreStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RestoreList contains a list of Restore
+type RestoreList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Restore `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Restore{}, &RestoreList{})
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SyncJobTemplate settings of the CronJob checking a database for drift, the fields set on a Database override the
+// ones the operator is configured with
+type SyncJobTemplate struct {
+	// Image of the sync job
+	Image string `json:"image,omitempty"`
+	// ImagePullPolicy of the sync image
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// ImagePullSecrets secrets in the namespace of the Database used to pull the sync image
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// ServiceAccountName service account in the namespace of the Database the sync job runs as
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// NodeSelector of the sync pods
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations of the sync pods
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// Resources of the sync container
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// SecurityContext of the sync container
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+	// PodSecurityContext of the sync pods
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+	// SuccessfulJobsHistoryLimit how many finished sync jobs are kept
+	// +kubebuilder:validation:Minimum=0
+	SuccessfulJobsHistoryLimit *int32 `json:"successfulJobsHistoryLimit,omitempty"`
+	// FailedJobsHistoryLimit how many failed sync jobs are kept
+	// +kubebuilder:validation:Minimum=0
+	FailedJobsHistoryLimit *int32 `json:"failedJobsHistoryLimit,omitempty"`
+	// ConcurrencyPolicy what happens when a sync job is due while the previous one still runs
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	ConcurrencyPolicy batch.ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// Suspend stops scheduling sync jobs
+	Suspend *bool `json:"suspend,omitempty"`
+}
@@ -3,18 +3,22 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/go-logr/logr"
 	"github.com/go-logr/zapr"
 	ms "github.com/pplavetzki/arc-sql-mi/internal"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
@@ -25,11 +29,6 @@ var (
 	clientset *kubernetes.Clientset
 )
 
-type DBResult struct {
-	Result *string
-	Error  error
-}
-
 func getEnvOrFail(key string) string {
 	var val string
 	if val = os.Getenv(key); val == "" {
@@ -38,64 +37,48 @@ func getEnvOrFail(key string) string {
 	return val
 }
 
-func getDatabaseID(ctx context.Context, msSQL *ms.MSSql, name string, result chan *DBResult) {
-	dbID, err := msSQL.FindDatabaseID(ctx, name)
-	result <- &DBResult{
-		Result: dbID,
-		Error:  err,
+// getEnvOrFile reads the variable key, or the file named by key_FILE when it is not set
+func getEnvOrFile(key string) (string, error) {
+	if val := os.Getenv(key); val != "" {
+		return val, nil
 	}
-}
-
-func getDatabaseName(ctx context.Context, msSQL *ms.MSSql, id string, result chan *DBResult) {
-	if id != "" {
-		dbName, err := msSQL.FindDatabaseName(ctx, id)
-		result <- &DBResult{
-			Result: dbName,
-			Error:  err,
-		}
-	} else {
-		result <- &DBResult{
-			Result: nil,
-			Error:  nil,
-		}
+	file := os.Getenv(key + "_FILE")
+	if file == "" {
+		return "", nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from %s: %v", key, file, err)
 	}
+	return strings.TrimRight(string(b), "\r\n"), nil
 }
 
-func performSync(msSQL *ms.MSSql, db *sqlmi.Database) error {
-	dbNameResult := make(chan *DBResult)
-	dbIDResult := make(chan *DBResult)
-
-	defer func() {
-		if msSQL.DB != nil {
-			msSQL.DB.Close()
-		}
-	}()
-
-	go getDatabaseID(context.TODO(), msSQL, db.Spec.Name, dbIDResult)
-	go getDatabaseName(context.TODO(), msSQL, db.Status.DatabaseID, dbNameResult)
-
-	dbNameR := <-dbNameResult
-	dbIDR := <-dbIDResult
-
-	if dbNameR.Error != nil {
-		panic(fmt.Errorf("failed to query name: %v", dbNameR.Error))
-	}
-	if dbIDR.Error != nil {
-		panic(fmt.Errorf("failed to query db id: %v", dbIDR.Error))
+// performSync compares the database on the instance with its spec and returns the settings that differ
+func performSync(msSQL *ms.MSSql, db *sqlmi.Database) ([]ms.FieldDrift, error) {
+	dbID, err := msSQL.FindDatabaseID(context.TODO(), db.Spec.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query db id: %w", err)
 	}
-	if dbIDR.Result != nil {
-		logger.V(1).Info("found database ID", "database-id", *dbIDR.Result)
+	if dbID != nil {
+		logger.V(1).Info("found database ID", "database-id", *dbID)
 	}
-	if dbNameR.Result != nil {
-		logger.V(1).Info("database name", "database-name", *dbNameR.Result)
+	if db.Status.DatabaseID != "" {
+		dbName, err := msSQL.FindDatabaseName(context.TODO(), db.Status.DatabaseID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query name: %w", err)
+		}
+		if dbName != nil {
+			logger.V(1).Info("database name", "database-name", *dbName)
+		}
 	}
 
-	if db.Status.DatabaseID == "" && dbIDR.Result == nil {
+	if db.Status.DatabaseID == "" && dbID == nil {
 		logger.V(0).Info("database does not exist and is not managed by database controller -- serious error", "databaseName", db.Spec.Name)
-	} else if db.Status.DatabaseID == "" && dbIDR.Result != nil {
-		logger.V(0).Info("database exists on server but not managed by database controller", "databaseName", db.Spec.Name, "guid", *dbIDR.Result)
-	} else if db.Status.DatabaseID != "" && (dbIDR.Result != nil && *dbIDR.Result != db.Status.DatabaseID) {
-		logger.V(0).Info("database on server does not match what database controller is expecting", "databaseName", db.Spec.Name, "databaseGuid", *dbIDR.Result, "controllerGuid", db.Status.DatabaseID)
+	} else if db.Status.DatabaseID == "" && dbID != nil {
+		logger.V(0).Info("database exists on server but is not managed by the database controller yet, it is adopted only when the adoption policy allows it",
+			"databaseName", db.Spec.Name, "guid", *dbID, "adoptionPolicy", db.Spec.AdoptionPolicy)
+	} else if db.Status.DatabaseID != "" && (dbID != nil && *dbID != db.Status.DatabaseID) {
+		logger.V(0).Info("database on server does not match what database controller is expecting", "databaseName", db.Spec.Name, "databaseGuid", *dbID, "controllerGuid", db.Status.DatabaseID)
 	}
 	// Now let's sync
 	params := &ms.DatabaseConfig{
@@ -109,39 +92,82 @@ func performSync(msSQL *ms.MSSql, db *sqlmi.Database) error {
 	}
 	syncResponse, err := msSQL.SyncNeeded(context.TODO(), params, ms.Database)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if syncResponse != nil {
-		logger.V(0).Info("database is out-of-sync with database controller", "database", syncResponse)
+	drift := ms.DatabaseDrift(params, syncResponse)
+	if drift != nil {
+		logger.V(0).Info("database is out-of-sync with database controller", "drift", drift)
 	} else {
 		logger.V(0).Info("database sync not needed")
 	}
-	return nil
+	return drift, nil
 }
 
-func connectionInfo(db *sqlmi.Database) (string, string, error) {
+// recordSync patches the result of the sync onto the status of the database, the controller corrects the drift
+// when it reconciles the status change
+func recordSync(cl client.Client, key client.ObjectKey, drift []ms.FieldDrift) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		db := &sqlmi.Database{}
+		if err := cl.Get(context.TODO(), key, db); err != nil {
+			return err
+		}
+		patch := client.MergeFromWithOptions(db.DeepCopy(), client.MergeFromWithOptimisticLock{})
+
+		now := v1.Now()
+		db.Status.LastSyncTime = &now
+		db.Status.Drift = nil
+		fields := make([]string, 0, len(drift))
+		for _, d := range drift {
+			db.Status.Drift = append(db.Status.Drift, sqlmi.FieldDrift{Field: d.Field, Expected: d.Expected, Actual: d.Actual})
+			fields = append(fields, d.String())
+		}
+		if len(drift) > 0 {
+			meta.SetStatusCondition(&db.Status.Conditions, *db.DriftDetectedCondition("drifted settings: " + strings.Join(fields, "; ")))
+		} else {
+			meta.SetStatusCondition(&db.Status.Conditions, *db.InSyncCondition())
+		}
+		return cl.Status().Patch(context.TODO(), db, patch)
+	})
+}
+
+// connectionInfo reads the login named by the credentials of the database, like the controller it only falls back
+// to the admin login of the instance when ALLOW_INSTANCE_ADMIN_CREDENTIALS is `true`
+func connectionInfo(db *sqlmi.Database, mi *ms.SQLManagedInstance) (string, string, error) {
 	/*******************************************************************************************************************
 	* Quering the defined secret for the database connection
 	*******************************************************************************************************************/
-	mi, err := ms.QuerySQLManagedInstance(context.TODO(), db.Namespace, db.Spec.SQLManagedInstance)
-	if err != nil {
-		return "", "", err
-	}
-	logger.V(1).Info("successfully found managed instance", "sql-managed-instance", db.Spec.SQLManagedInstance)
 	if mi.Status.State != "Ready" {
 		return "", "", fmt.Errorf("the sql managed instance is not in a `Ready` state, current status is: %v", mi.Status)
 	}
 
-	sec, err := clientset.CoreV1().Secrets(mi.Spec.LoginRef.Namespace).Get(context.TODO(), mi.Spec.LoginRef.Name, v1.GetOptions{})
+	namespace, name, usernameKey, passwordKey := mi.Spec.LoginRef.Namespace, mi.Spec.LoginRef.Name, "username", "password"
+	if namespace == "" {
+		namespace = mi.Namespace
+	}
+	if ref := db.Spec.Credentials; ref != nil && ref.Name != "" {
+		namespace, name = db.Namespace, ref.Name
+		if ref.UsernameKey != "" {
+			usernameKey = ref.UsernameKey
+		}
+		if ref.PasswordKey != "" {
+			passwordKey = ref.PasswordKey
+		}
+	} else if os.Getenv("ALLOW_INSTANCE_ADMIN_CREDENTIALS") != "true" {
+		return "", "", fmt.Errorf("the database names no credentials secret and the admin login of the instance is not allowed")
+	}
+
+	sec, err := clientset.CoreV1().Secrets(namespace).Get(context.TODO(), name, v1.GetOptions{})
 	if err != nil {
-		logger.Error(err, "secrets credentials resource not found", "secret-name", mi.Spec.LoginRef.Name)
+		logger.Error(err, "secrets credentials resource not found", "secret-name", name)
 		return "", "", err
 	}
-
-	username := sec.Data["username"]
-	password := sec.Data["password"]
+	for _, key := range []string{usernameKey, passwordKey} {
+		if len(sec.Data[key]) == 0 {
+			return "", "", fmt.Errorf("credentials secret: %s does not contain the key: %s", name, key)
+		}
+	}
 	/******************************************************************************************************************/
-	return string(username), string(password), nil
+	return string(sec.Data[usernameKey]), string(sec.Data[passwordKey]), nil
 }
 
 func main() {
@@ -155,9 +181,6 @@ func main() {
 
 	namespace := getEnvOrFail("NAMESPACE")
 	databaseCRD := getEnvOrFail("DATABASE_CRD")
-	password := getEnvOrFail("DATABASE_PASSWORD")
-	user := getEnvOrFail("DATABASE_USER")
-	port := getEnvOrFail("DATABASE_PORT")
 
 	if os.Getenv("KUBECONFIG") != "" {
 		path := os.Getenv("KUBECONFIG")
@@ -183,33 +206,73 @@ func main() {
 
 	crScheme := runtime.NewScheme()
 	sqlmi.AddToScheme(crScheme)
+	ms.AddToScheme(crScheme)
 
-	cl, _ := client.New(config, client.Options{
+	cl, err := client.New(config, client.Options{
 		Scheme: crScheme,
 	})
-
-	list := &sqlmi.DatabaseList{}
-	err = cl.List(context.TODO(), list, &client.ListOptions{})
 	if err != nil {
-		panic(err.Error())
+		panic(err)
 	}
-	db := &sqlmi.Database{}
 
-	cl.Get(context.TODO(), client.ObjectKey{
-		Namespace: namespace,
-		Name:      databaseCRD,
-	}, db)
+	// a failed sync exits non-zero so it shows up in the failed job history of the CronJob
+	if err = run(cl, client.ObjectKey{Namespace: namespace, Name: databaseCRD}); err != nil {
+		logger.Error(err, "sync failed", "database", databaseCRD, "namespace", namespace)
+		os.Exit(1)
+	}
+}
 
-	var server string
+// run connects to the instance of the database, compares the database with its spec and records the result
+func run(cl client.Client, key client.ObjectKey) error {
+	db := &sqlmi.Database{}
+	if err := cl.Get(context.TODO(), key, db); err != nil {
+		return err
+	}
+
+	// MS_SERVER and DATABASE_PORT override the endpoint, the same way the spec of the database does
+	server := db.Spec.Server
 	if os.Getenv("MS_SERVER") != "" {
 		server = os.Getenv("MS_SERVER")
-	} else {
-		server = fmt.Sprintf("%s-p-svc", db.Spec.SQLManagedInstance)
 	}
-	p, err := strconv.Atoi(port)
+	p := db.Spec.Port
+	if port := os.Getenv("DATABASE_PORT"); port != "" {
+		var err error
+		if p, err = strconv.Atoi(port); err != nil {
+			return err
+		}
+	}
+	if db.Spec.DriftPolicy == sqlmi.DriftIgnore {
+		logger.V(0).Info("drift policy is Ignore, not checking the database", "database", db.Spec.Name)
+		return nil
+	}
+
+	mi := &ms.SQLManagedInstance{}
+	if err := cl.Get(context.TODO(), client.ObjectKey{Namespace: db.Namespace, Name: db.Spec.SQLManagedInstance}, mi); err != nil {
+		return err
+	}
+	endpoint, err := ms.ResolveEndpoint(mi, server, p)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	// the controller mounts the login it provisions with into the job, without it the job reads it the same way
+	user, err := getEnvOrFile("DATABASE_USER")
+	if err != nil {
+		return err
+	}
+	password, err := getEnvOrFile("DATABASE_PASSWORD")
+	if err != nil {
+		return err
+	}
+	if user == "" || password == "" {
+		if user, password, err = connectionInfo(db, mi); err != nil {
+			return err
+		}
+	}
+	msSQL := ms.NewMSSql(endpoint.Server, user, password, endpoint.Port)
+
+	drift, err := performSync(msSQL, db)
+	if err != nil {
+		return err
 	}
-	msSQL := ms.NewMSSql(server, user, password, p)
-	performSync(msSQL, db)
+	return recordSync(cl, key, drift)
 }
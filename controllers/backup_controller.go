@@ -0,0 +1,346 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+)
+
+// backupPollInterval how often the progress of a running backup is checked
+const backupPollInterval = 10 * time.Second
+
+// BackupReconciler reconciles a Backup object
+type BackupReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger logr.Logger
+	// AllowInstanceAdminCredentials takes backups of databases without credentials with the admin login of the instance
+	AllowInstanceAdminCredentials bool
+	// Pool shares the connections to the instances between reconciles
+	Pool *ms.Pool
+
+	mu sync.Mutex
+	// backups running in this process keyed by the uid of their Backup
+	backups map[types.UID]*ms.BackupOperation
+}
+
+func (r *BackupReconciler) operation(uid types.UID) *ms.BackupOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.backups[uid]
+}
+
+func (r *BackupReconciler) track(uid types.UID, op *ms.BackupOperation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.backups == nil {
+		r.backups = map[types.UID]*ms.BackupOperation{}
+	}
+	r.backups[uid] = op
+}
+
+func (r *BackupReconciler) forget(uid types.UID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.backups, uid)
+}
+
+// backupTypes maps the api backup type to the statement keyword
+var backupTypes = map[sqlmi.BackupType]string{
+	sqlmi.BackupFull:         ms.BackupFull,
+	sqlmi.BackupDifferential: ms.BackupDifferential,
+	sqlmi.BackupLog:          ms.BackupLog,
+}
+
+func (r *BackupReconciler) updateBackupStatus(ctx context.Context, backup *sqlmi.Backup, status string, condition *metav1.Condition) error {
+	backup.Status.Status = status
+	if condition != nil {
+		meta.SetStatusCondition(&backup.Status.Conditions, *condition)
+	}
+	if status != sqlmi.BackupConditionError {
+		meta.RemoveStatusCondition(&backup.Status.Conditions, sqlmi.BackupConditionError)
+	}
+	return r.Status().Update(ctx, backup)
+}
+
+// pruneBackup deletes the file of a completed backup
+func (r *BackupReconciler) pruneBackup(ctx context.Context, backup *sqlmi.Backup) error {
+	if backup.Status.Status != sqlmi.BackupConditionCompleted {
+		return nil
+	}
+	if backup.Spec.Destination.Disk == "" {
+		r.Logger.Info("backups in blob storage are not pruned", "backup", backup.Name, "url", backup.Spec.Destination.URL)
+		return nil
+	}
+	_, msSQL, _, err := connectToDatabase(ctx, r.Client, r.Pool, backup.Namespace, backup.Spec.DatabaseRef, r.AllowInstanceAdminCredentials)
+	if err != nil {
+		if errors.IsNotFound(err) || err == errDatabasePending {
+			r.Logger.Info("database is gone, leaving the backup file behind", "backup", backup.Name, "disk", backup.Spec.Destination.Disk)
+			return nil
+		}
+		if msSQL == nil {
+			return err
+		}
+	}
+	return msSQL.DeleteBackupFile(ctx, backup.Spec.Destination.Disk)
+}
+
+// updateBackupProgress records the progress of the running backup when it moved
+func (r *BackupReconciler) updateBackupProgress(ctx context.Context, backup *sqlmi.Backup, progress float64) error {
+	if int(progress) == backup.Status.PercentComplete {
+		return nil
+	}
+	backup.Status.PercentComplete = int(progress)
+	return r.Status().Update(ctx, backup)
+}
+
+// recordLastBackup surfaces the finish time of the backup on the Database status
+func (r *BackupReconciler) recordLastBackup(ctx context.Context, backup *sqlmi.Backup) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		db := &sqlmi.Database{}
+		if err := r.Get(ctx, types.NamespacedName{Name: backup.Spec.DatabaseRef, Namespace: backup.Namespace}, db); err != nil {
+			return client.IgnoreNotFound(err)
+		}
+		if db.Status.DatabaseID != backup.Status.DatabaseID {
+			return nil
+		}
+		if db.Status.LastBackupTime != nil && !db.Status.LastBackupTime.Before(backup.Status.FinishTime) {
+			return nil
+		}
+		db.Status.LastBackupTime = backup.Status.FinishTime
+		return r.Status().Update(ctx, db)
+	})
+}
+
+// failBackup records the error on the backup status and hands it back to the caller when retrying can help
+func (r *BackupReconciler) failBackup(ctx context.Context, backup *sqlmi.Backup, err error) error {
+	if uerr := r.updateBackupStatus(ctx, backup, sqlmi.BackupConditionError, sqlCondition(backup.ErroredCondition(err.Error()), err)); uerr != nil {
+		r.Logger.Error(uerr, "failed to update Backup status")
+	}
+	return retryable(err)
+}
+
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=backups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=backups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=backups/finalizers,verbs=update
+
+// Reconcile runs the backup described by a Backup object once and records the backup set in its status
+func (r *BackupReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	logger := r.Logger.WithValues("backup", req.NamespacedName)
+	logger.Info("reconciling backup")
+
+	backup := &sqlmi.Backup{}
+	err := r.Get(ctx, req.NamespacedName, backup)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Backup resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get Backup")
+		return ctrl.Result{}, err
+	}
+
+	/*******************************************************************************************************************
+	* Finalizer to prune the backup file when the resource is deleted
+	*******************************************************************************************************************/
+	if backup.ObjectMeta.DeletionTimestamp.IsZero() {
+		if backup.Spec.PruneOnDelete && !controllerutil.ContainsFinalizer(backup, databaseFinalizer) {
+			controllerutil.AddFinalizer(backup, databaseFinalizer)
+			if err = r.Update(ctx, backup); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(backup, databaseFinalizer) {
+			if err = r.pruneBackup(ctx, backup); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(backup, databaseFinalizer)
+		if err := r.Update(ctx, backup); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	/******************************************************************************************************************/
+
+	// a backup is taken once, a new Backup object is needed to take another one
+	if backup.Status.Status == sqlmi.BackupConditionCompleted || backup.Status.Status == sqlmi.BackupConditionFailed {
+		return ctrl.Result{}, nil
+	}
+
+	backupType, ok := backupTypes[backup.Spec.Type]
+	if !ok {
+		return ctrl.Result{}, r.updateBackupStatus(ctx, backup, sqlmi.BackupConditionFailed,
+			backup.FailedCondition(fmt.Sprintf("invalid backup type: %q", backup.Spec.Type)))
+	}
+
+	db, msSQL, databaseName, err := connectToDatabase(ctx, r.Client, r.Pool, backup.Namespace, backup.Spec.DatabaseRef, r.AllowInstanceAdminCredentials)
+	if err != nil {
+		if errors.IsNotFound(err) || err == errDatabasePending {
+			// the Database watch brings us back once the database exists
+			logger.Info("waiting for the referenced database", "database", backup.Spec.DatabaseRef)
+			return ctrl.Result{}, r.updateBackupStatus(ctx, backup, sqlmi.BackupConditionPending, backup.PendingCondition())
+		}
+		return ctrl.Result{}, r.failBackup(ctx, backup, err)
+	}
+
+	params := &ms.BackupParams{
+		Type:        backupType,
+		Disk:        backup.Spec.Destination.Disk,
+		URL:         backup.Spec.Destination.URL,
+		CopyOnly:    backup.Spec.CopyOnly,
+		Compression: backup.Spec.Compression,
+	}
+
+	// the backup runs in the background so a large one does not hold up the other backups
+	var result *ms.BackupResult
+	op := r.operation(backup.UID)
+	switch {
+	case op == nil && backup.Status.Status == sqlmi.BackupConditionRunning:
+		// this process did not start the backup, it kept running on the instance while the operator restarted
+		progress, err := msSQL.BackupProgress(ctx, backup.Status.SessionID)
+		if err != nil {
+			logger.Error(err, "failed to query backup progress", "session-id", backup.Status.SessionID)
+			return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+		}
+		if progress != nil {
+			return ctrl.Result{RequeueAfter: backupPollInterval}, r.updateBackupProgress(ctx, backup, *progress)
+		}
+		since := backup.CreationTimestamp.Time
+		if backup.Status.StartTime != nil {
+			since = backup.Status.StartTime.Time
+		}
+		// the instance clock runs in utc
+		result, err = msSQL.BackupRecorded(ctx, databaseName, params, since.UTC())
+		if err != nil {
+			return ctrl.Result{}, r.failBackup(ctx, backup, err)
+		}
+		if result == nil {
+			return ctrl.Result{}, r.updateBackupStatus(ctx, backup, sqlmi.BackupConditionFailed,
+				backup.FailedCondition("the backup was interrupted by a restart of the operator"))
+		}
+
+	case op == nil:
+		op, err = msSQL.StartBackup(ctx, databaseName, params)
+		if err != nil {
+			return ctrl.Result{}, r.failBackup(ctx, backup, err)
+		}
+		r.track(backup.UID, op)
+
+		now := metav1.Now()
+		backup.Status.DatabaseID = db.Status.DatabaseID
+		backup.Status.StartTime = &now
+		backup.Status.SessionID = op.SessionID
+		backup.Status.PercentComplete = 0
+		if err = r.updateBackupStatus(ctx, backup, sqlmi.BackupConditionRunning, backup.RunningCondition()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+
+	case !op.Done():
+		progress, err := msSQL.BackupProgress(ctx, op.SessionID)
+		if err != nil {
+			logger.Error(err, "failed to query backup progress", "session-id", op.SessionID)
+			return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+		}
+		if progress != nil {
+			return ctrl.Result{RequeueAfter: backupPollInterval}, r.updateBackupProgress(ctx, backup, *progress)
+		}
+		return ctrl.Result{RequeueAfter: backupPollInterval}, nil
+
+	default:
+		r.forget(backup.UID)
+		if result, err = op.Result(); err != nil {
+			logger.Error(err, "backup failed", "database", databaseName)
+			return ctrl.Result{}, r.updateBackupStatus(ctx, backup, sqlmi.BackupConditionFailed, sqlCondition(backup.FailedCondition(err.Error()), err))
+		}
+	}
+
+	startTime, finishTime := metav1.NewTime(result.StartTime), metav1.NewTime(result.FinishTime)
+	backup.Status.PercentComplete = 100
+	backup.Status.StartTime = &startTime
+	backup.Status.FinishTime = &finishTime
+	backup.Status.Size = result.Size
+	backup.Status.CompressedSize = result.CompressedSize
+	backup.Status.FirstLSN = result.FirstLSN
+	backup.Status.LastLSN = result.LastLSN
+	if err = r.updateBackupStatus(ctx, backup, sqlmi.BackupConditionCompleted, backup.CompletedCondition()); err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = r.recordLastBackup(ctx, backup); err != nil {
+		logger.Error(err, "failed to record the last backup time on the Database", "database", backup.Spec.DatabaseRef)
+	}
+	return ctrl.Result{}, nil
+}
+
+// requestsForDatabase maps a Database to the backups waiting on it
+func (r *BackupReconciler) requestsForDatabase(obj client.Object) []reconcile.Request {
+	backups := &sqlmi.BackupList{}
+	if err := r.List(context.Background(), backups, client.InNamespace(obj.GetNamespace()), client.MatchingFields{databaseRefField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list Backups for Database", "database", obj.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, backup := range backups.Items {
+		if backup.Status.Status == sqlmi.BackupConditionCompleted || backup.Status.Status == sqlmi.BackupConditionFailed {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: backup.Name, Namespace: backup.Namespace}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Pool == nil {
+		r.Pool = ms.NewPool(ms.DefaultPoolOptions())
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Backup{}, databaseRefField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.Backup).Spec.DatabaseRef}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&sqlmi.Backup{}).
+		Watches(&source.Kind{Type: &sqlmi.Database{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForDatabase)).
+		Complete(r)
+}
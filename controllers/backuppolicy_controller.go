@@ -0,0 +1,375 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+)
+
+const (
+	// backupPolicyLabel names the BackupPolicy that scheduled a Backup
+	backupPolicyLabel = "sqlmi.arc-sql-mi.microsoft.io/backup-policy"
+	// backupDatabaseLabel names the Database a scheduled Backup is taken of
+	backupDatabaseLabel = "sqlmi.arc-sql-mi.microsoft.io/database"
+	// backupTypeLabel the type of a scheduled Backup
+	backupTypeLabel = "sqlmi.arc-sql-mi.microsoft.io/backup-type"
+)
+
+// BackupPolicyReconciler reconciles a BackupPolicy object
+type BackupPolicyReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger logr.Logger
+}
+
+type policySchedule struct {
+	backupType sqlmi.BackupType
+	schedule   *sqlmi.BackupSchedule
+}
+
+// policySchedules the configured schedules of the policy
+func policySchedules(policy *sqlmi.BackupPolicy) []policySchedule {
+	schedules := []policySchedule{}
+	for _, s := range []policySchedule{
+		{sqlmi.BackupFull, policy.Spec.Full},
+		{sqlmi.BackupDifferential, policy.Spec.Differential},
+		{sqlmi.BackupLog, policy.Spec.Log},
+	} {
+		if s.schedule != nil {
+			schedules = append(schedules, s)
+		}
+	}
+	return schedules
+}
+
+// backupDestination the file a scheduled backup of the database is written to
+func backupDestination(policy *sqlmi.BackupPolicy, db *sqlmi.Database, backupType sqlmi.BackupType, at time.Time) sqlmi.BackupLocation {
+	extension := "bak"
+	if backupType == sqlmi.BackupLog {
+		extension = "trn"
+	}
+	file := fmt.Sprintf("%s-%s-%s.%s", db.Spec.Name, strings.ToLower(string(backupType)), at.UTC().Format("20060102T150405Z"), extension)
+	if policy.Spec.Destination.URL != "" {
+		return sqlmi.BackupLocation{URL: strings.TrimSuffix(policy.Spec.Destination.URL, "/") + "/" + file}
+	}
+	return sqlmi.BackupLocation{Disk: path.Join(policy.Spec.Destination.Disk, file)}
+}
+
+func (r *BackupPolicyReconciler) updatePolicyStatus(ctx context.Context, policy *sqlmi.BackupPolicy, status string, condition *metav1.Condition) error {
+	policy.Status.Status = status
+	if condition != nil {
+		meta.SetStatusCondition(&policy.Status.Conditions, *condition)
+	}
+	if status != sqlmi.BackupPolicyConditionError {
+		meta.RemoveStatusCondition(&policy.Status.Conditions, sqlmi.BackupPolicyConditionError)
+	}
+	return r.Status().Update(ctx, policy)
+}
+
+// failPolicy records the error on the policy status and hands it back to the caller
+func (r *BackupPolicyReconciler) failPolicy(ctx context.Context, policy *sqlmi.BackupPolicy, err error) error {
+	if uerr := r.updatePolicyStatus(ctx, policy, sqlmi.BackupPolicyConditionError, policy.ErroredCondition(err.Error())); uerr != nil {
+		r.Logger.Error(uerr, "failed to update BackupPolicy status")
+	}
+	return err
+}
+
+// scheduleBackup creates the Backup of the database for a schedule that fired at the given time
+func (r *BackupPolicyReconciler) scheduleBackup(ctx context.Context, policy *sqlmi.BackupPolicy, db *sqlmi.Database, backupType sqlmi.BackupType, at time.Time) error {
+	backup := &sqlmi.Backup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%s-%s-%d", policy.Name, db.Name, strings.ToLower(string(backupType)), at.Unix()),
+			Namespace: policy.Namespace,
+			Labels: map[string]string{
+				backupPolicyLabel:   policy.Name,
+				backupDatabaseLabel: db.Name,
+				backupTypeLabel:     string(backupType),
+			},
+		},
+		Spec: sqlmi.BackupSpec{
+			DatabaseRef:   db.Name,
+			Type:          backupType,
+			Destination:   backupDestination(policy, db, backupType, at),
+			Compression:   policy.Spec.Compression,
+			PruneOnDelete: policy.Spec.Destination.Disk != "",
+		},
+	}
+	if err := r.Create(ctx, backup); err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// defaultBackupRetention the completed backups of a type kept per database when its schedule sets no retention
+const defaultBackupRetention = 7
+
+// policyRetention the retention of each configured schedule, backups in blob storage are never pruned so a policy
+// writing to a url cannot set one
+func policyRetention(policy *sqlmi.BackupPolicy) (map[sqlmi.BackupType]int, error) {
+	retention := map[sqlmi.BackupType]int{}
+	for _, ps := range policySchedules(policy) {
+		if policy.Spec.Destination.URL != "" {
+			if ps.schedule.Retention > 0 {
+				return nil, fmt.Errorf("backups in blob storage are not pruned, retention cannot be set on the %s schedule of a url destination", strings.ToLower(string(ps.backupType)))
+			}
+			continue
+		}
+		retention[ps.backupType] = ps.schedule.Retention
+		if retention[ps.backupType] < 1 {
+			retention[ps.backupType] = defaultBackupRetention
+		}
+	}
+	return retention, nil
+}
+
+// expiredBackups the completed backups beyond the retention of their type, pruned by chain so nothing that is kept
+// depends on them. A full backup beyond its retention takes its whole chain along, every backup finished before
+// the oldest full backup kept. Past their own retention, a differential backup is always expired since nothing
+// builds on it, and a log backup only once a newer full or differential backup restores past it
+func expiredBackups(completed []sqlmi.Backup, retention map[sqlmi.BackupType]int) []sqlmi.Backup {
+	sorted := append([]sqlmi.Backup{}, completed...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Status.FinishTime.Before(sorted[j].Status.FinishTime)
+	})
+	byType := map[sqlmi.BackupType][]int{}
+	for i, backup := range sorted {
+		byType[backup.Spec.Type] = append(byType[backup.Spec.Type], i)
+	}
+
+	// the chains of the full backups beyond their retention
+	chainStart := -1
+	if fulls := byType[sqlmi.BackupFull]; retention[sqlmi.BackupFull] > 0 && len(fulls) > retention[sqlmi.BackupFull] {
+		chainStart = fulls[len(fulls)-retention[sqlmi.BackupFull]]
+	}
+	// the newest base a restore starts from
+	lastBase := -1
+	for i, backup := range sorted {
+		if backup.Spec.Type == sqlmi.BackupFull || backup.Spec.Type == sqlmi.BackupDifferential {
+			lastBase = i
+		}
+	}
+
+	expired := map[int]bool{}
+	for i := 0; i < chainStart; i++ {
+		expired[i] = true
+	}
+	if diffs := byType[sqlmi.BackupDifferential]; retention[sqlmi.BackupDifferential] > 0 && len(diffs) > retention[sqlmi.BackupDifferential] {
+		for _, i := range diffs[:len(diffs)-retention[sqlmi.BackupDifferential]] {
+			expired[i] = true
+		}
+	}
+	if logs := byType[sqlmi.BackupLog]; retention[sqlmi.BackupLog] > 0 && len(logs) > retention[sqlmi.BackupLog] {
+		for _, i := range logs[:len(logs)-retention[sqlmi.BackupLog]] {
+			if i < lastBase {
+				expired[i] = true
+			}
+		}
+	}
+
+	backups := []sqlmi.Backup{}
+	for i, backup := range sorted {
+		if expired[i] {
+			backups = append(backups, backup)
+		}
+	}
+	return backups
+}
+
+// pruneBackups deletes the completed backups of the database the policy no longer keeps
+func (r *BackupPolicyReconciler) pruneBackups(ctx context.Context, policy *sqlmi.BackupPolicy, db *sqlmi.Database, retention map[sqlmi.BackupType]int) error {
+	if len(retention) == 0 {
+		return nil
+	}
+	backups := &sqlmi.BackupList{}
+	if err := r.List(ctx, backups, client.InNamespace(policy.Namespace), client.MatchingLabels{
+		backupPolicyLabel:   policy.Name,
+		backupDatabaseLabel: db.Name,
+	}); err != nil {
+		return err
+	}
+	completed := []sqlmi.Backup{}
+	for _, backup := range backups.Items {
+		if backup.Status.Status == sqlmi.BackupConditionCompleted && backup.DeletionTimestamp.IsZero() {
+			completed = append(completed, backup)
+		}
+	}
+	expired := expiredBackups(completed, retention)
+	for i := range expired {
+		r.Logger.Info("pruning expired backup", "backup", expired[i].Name, "type", expired[i].Spec.Type, "database", db.Name)
+		if err := r.Delete(ctx, &expired[i]); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=backuppolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=backuppolicies/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=backuppolicies/finalizers,verbs=update
+
+// Reconcile creates the Backup objects of the selected databases when a schedule fires and prunes the expired ones
+func (r *BackupPolicyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	logger := r.Logger.WithValues("backuppolicy", req.NamespacedName)
+	logger.Info("reconciling backup policy")
+
+	policy := &sqlmi.BackupPolicy{}
+	err := r.Get(ctx, req.NamespacedName, policy)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("BackupPolicy resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get BackupPolicy")
+		return ctrl.Result{}, err
+	}
+
+	if (policy.Spec.Destination.Disk == "") == (policy.Spec.Destination.URL == "") {
+		return ctrl.Result{}, r.updatePolicyStatus(ctx, policy, sqlmi.BackupPolicyConditionError,
+			policy.ErroredCondition("exactly one of disk or url must be set on the destination"))
+	}
+	retention, err := policyRetention(policy)
+	if err != nil {
+		return ctrl.Result{}, r.updatePolicyStatus(ctx, policy, sqlmi.BackupPolicyConditionError, policy.ErroredCondition(err.Error()))
+	}
+	selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.Selector)
+	if err != nil {
+		return ctrl.Result{}, r.updatePolicyStatus(ctx, policy, sqlmi.BackupPolicyConditionError, policy.ErroredCondition(err.Error()))
+	}
+	dbs := &sqlmi.DatabaseList{}
+	if err = r.List(ctx, dbs, client.InNamespace(policy.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return ctrl.Result{}, r.failPolicy(ctx, policy, err)
+	}
+	policy.Status.Databases = []string{}
+	for _, db := range dbs.Items {
+		policy.Status.Databases = append(policy.Status.Databases, db.Name)
+	}
+
+	now := time.Now()
+	next := time.Time{}
+	schedules := []sqlmi.BackupScheduleStatus{}
+	for _, ps := range policySchedules(policy) {
+		schedule, err := ms.ParseSchedule(ps.schedule.Schedule)
+		if err != nil {
+			return ctrl.Result{}, r.updatePolicyStatus(ctx, policy, sqlmi.BackupPolicyConditionError, policy.ErroredCondition(err.Error()))
+		}
+
+		scheduleStatus := sqlmi.BackupScheduleStatus{Type: ps.backupType}
+		since := policy.CreationTimestamp.Time
+		for _, s := range policy.Status.Schedules {
+			if s.Type == ps.backupType && s.LastScheduleTime != nil {
+				scheduleStatus.LastScheduleTime = s.LastScheduleTime
+				since = s.LastScheduleTime.Time
+			}
+		}
+
+		// only the most recent missed run is caught up on
+		if at := schedule.LastBefore(since, now); !at.IsZero() {
+			for i := range dbs.Items {
+				if !dbs.Items[i].DeletionTimestamp.IsZero() {
+					continue
+				}
+				logger.Info("scheduling backup", "database", dbs.Items[i].Name, "type", ps.backupType, "scheduled-at", at)
+				if err = r.scheduleBackup(ctx, policy, &dbs.Items[i], ps.backupType, at); err != nil {
+					return ctrl.Result{}, r.failPolicy(ctx, policy, err)
+				}
+			}
+			scheduleStatus.LastScheduleTime = &metav1.Time{Time: at}
+		}
+
+		if n := schedule.Next(now); !n.IsZero() {
+			scheduleStatus.NextScheduleTime = &metav1.Time{Time: n}
+			if next.IsZero() || n.Before(next) {
+				next = n
+			}
+		}
+		schedules = append(schedules, scheduleStatus)
+	}
+	policy.Status.Schedules = schedules
+
+	for i := range dbs.Items {
+		if err = r.pruneBackups(ctx, policy, &dbs.Items[i], retention); err != nil {
+			return ctrl.Result{}, r.failPolicy(ctx, policy, err)
+		}
+	}
+
+	if err = r.updatePolicyStatus(ctx, policy, sqlmi.BackupPolicyConditionScheduled, policy.ScheduledCondition()); err != nil {
+		return ctrl.Result{}, err
+	}
+	if next.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: next.Sub(now)}, nil
+}
+
+// requestsForBackup maps a scheduled Backup to the policy that created it
+func (r *BackupPolicyReconciler) requestsForBackup(obj client.Object) []reconcile.Request {
+	name, ok := obj.GetLabels()[backupPolicyLabel]
+	if !ok {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: name, Namespace: obj.GetNamespace()}}}
+}
+
+// requestsForDatabase maps a Database to the policies selecting it
+func (r *BackupPolicyReconciler) requestsForDatabase(obj client.Object) []reconcile.Request {
+	policies := &sqlmi.BackupPolicyList{}
+	if err := r.List(context.Background(), policies, client.InNamespace(obj.GetNamespace())); err != nil {
+		r.Logger.Error(err, "failed to list BackupPolicies for Database", "database", obj.GetName())
+		return nil
+	}
+	requests := []reconcile.Request{}
+	for _, policy := range policies.Items {
+		selector, err := metav1.LabelSelectorAsSelector(&policy.Spec.Selector)
+		if err != nil || !selector.Matches(labels.Set(obj.GetLabels())) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BackupPolicyReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&sqlmi.BackupPolicy{}).
+		Watches(&source.Kind{Type: &sqlmi.Backup{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForBackup)).
+		Watches(&source.Kind{Type: &sqlmi.Database{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForDatabase)).
+		Complete(r)
+}
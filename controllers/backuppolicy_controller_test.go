@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExpiredBackups(t *testing.T) {
+	start := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	// one backup an hour named after its type and the hour it finished
+	backups := func(types ...sqlmi.BackupType) []sqlmi.Backup {
+		backups := []sqlmi.Backup{}
+		for i, backupType := range types {
+			finished := metav1.NewTime(start.Add(time.Duration(i) * time.Hour))
+			backup := sqlmi.Backup{Spec: sqlmi.BackupSpec{Type: backupType}, Status: sqlmi.BackupStatus{FinishTime: &finished}}
+			backup.Name = string(backupType[0]) + string(rune('0'+i))
+			backups = append(backups, backup)
+		}
+		return backups
+	}
+	full, diff, log := sqlmi.BackupFull, sqlmi.BackupDifferential, sqlmi.BackupLog
+	tests := []struct {
+		name      string
+		completed []sqlmi.Backup
+		retention map[sqlmi.BackupType]int
+		want      []string
+	}{
+		{
+			name:      "within retention",
+			completed: backups(full, log, diff, log),
+			retention: map[sqlmi.BackupType]int{full: 2, diff: 2, log: 2},
+			want:      []string{},
+		},
+		{
+			name:      "expired full takes its chain",
+			completed: backups(full, diff, log, full, log, diff, log),
+			retention: map[sqlmi.BackupType]int{full: 1, diff: 7, log: 7},
+			want:      []string{"F0", "D1", "L2"},
+		},
+		{
+			name:      "differentials expire on their own",
+			completed: backups(full, diff, diff, diff),
+			retention: map[sqlmi.BackupType]int{full: 7, diff: 1},
+			want:      []string{"D1", "D2"},
+		},
+		{
+			name:      "logs after the newest base are kept past their retention",
+			completed: backups(full, log, log, diff, log, log, log),
+			retention: map[sqlmi.BackupType]int{full: 7, diff: 7, log: 1},
+			want:      []string{"L1", "L2"},
+		},
+		{
+			name:      "unscheduled types are only pruned with their chain",
+			completed: backups(full, log, full, log),
+			retention: map[sqlmi.BackupType]int{full: 1},
+			want:      []string{"F0", "L1"},
+		},
+		{
+			name:      "no retention",
+			completed: backups(full, full, full),
+			retention: map[sqlmi.BackupType]int{},
+			want:      []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the order backups are listed in does not matter
+			reversed := []sqlmi.Backup{}
+			for i := len(tt.completed) - 1; i >= 0; i-- {
+				reversed = append(reversed, tt.completed[i])
+			}
+			got := []string{}
+			for _, backup := range expiredBackups(reversed, tt.retention) {
+				got = append(got, backup.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expiredBackups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyRetention(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    sqlmi.BackupPolicySpec
+		want    map[sqlmi.BackupType]int
+		wantErr bool
+	}{
+		{
+			name: "disk defaults",
+			spec: sqlmi.BackupPolicySpec{
+				Destination: sqlmi.BackupLocation{Disk: "/var/opt/mssql/backups"},
+				Full:        &sqlmi.BackupSchedule{Schedule: "0 0 * * 0", Retention: 4},
+				Log:         &sqlmi.BackupSchedule{Schedule: "*/15 * * * *"},
+			},
+			want: map[sqlmi.BackupType]int{sqlmi.BackupFull: 4, sqlmi.BackupLog: defaultBackupRetention},
+		},
+		{
+			name: "url keeps every backup",
+			spec: sqlmi.BackupPolicySpec{
+				Destination: sqlmi.BackupLocation{URL: "https://acct.blob.core.windows.net/backups"},
+				Full:        &sqlmi.BackupSchedule{Schedule: "0 0 * * 0"},
+			},
+			want: map[sqlmi.BackupType]int{},
+		},
+		{
+			name: "url with retention",
+			spec: sqlmi.BackupPolicySpec{
+				Destination: sqlmi.BackupLocation{URL: "https://acct.blob.core.windows.net/backups"},
+				Full:        &sqlmi.BackupSchedule{Schedule: "0 0 * * 0", Retention: 4},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := policyRetention(&sqlmi.BackupPolicy{Spec: tt.spec})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("policyRetention() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("policyRetention() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -18,17 +18,30 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	"github.com/go-logr/logr"
 	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
@@ -41,11 +54,46 @@ import (
 const databaseFinalizer = "sqlmi.arc-sql-mi.microsoft.io/finalizer"
 const defaultSchedule = "0 */12 * * *"
 
+// databaseIDAnnotation holds the id of an existing database the Database object adopts
+const databaseIDAnnotation = "mssql/db_id"
+
+// sessionPollInterval how often the sessions blocking a drop are checked
+const sessionPollInterval = 10 * time.Second
+
+// finalBackupAnnotation records where the final backup of a BackupThenDelete database was written
+const finalBackupAnnotation = "sqlmi.arc-sql-mi.microsoft.io/final-backup"
+
+// finalBackupRunningAnnotation records the final backup while it runs, so a restarted operator follows it instead
+// of starting another one
+const finalBackupRunningAnnotation = "sqlmi.arc-sql-mi.microsoft.io/final-backup-running"
+
+// runningFinalBackup the final backup recorded in finalBackupRunningAnnotation
+type runningFinalBackup struct {
+	Disk      string    `json:"disk,omitempty"`
+	URL       string    `json:"url,omitempty"`
+	SessionID int       `json:"sessionID"`
+	StartTime time.Time `json:"startTime"`
+}
+
 // DatabaseReconciler reconciles a Database object
 type DatabaseReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Logger logr.Logger
+	Scheme   *runtime.Scheme
+	Logger   logr.Logger
+	Recorder record.EventRecorder
+	// AllowInstanceAdminCredentials provisions databases without credentials with the admin login of the instance
+	AllowInstanceAdminCredentials bool
+	// Pool shares the connections to the instances between reconciles
+	Pool *ms.Pool
+	// SyncJobTemplate the settings of the sync CronJobs, a Database overrides them with its own
+	SyncJobTemplate *sqlmi.SyncJobTemplate
+	// SyncClusterRole the cluster role bound to the service account of the sync CronJobs, empty leaves the binding to
+	// the cluster admin
+	SyncClusterRole string
+
+	mu sync.Mutex
+	// finalBackups the final backups running in this process keyed by the uid of their Database
+	finalBackups map[types.UID]*ms.BackupOperation
 }
 
 type AnnotationPatch struct {
@@ -59,8 +107,11 @@ func (a AnnotationPatch) Type() types.PatchType {
 
 func (a AnnotationPatch) Data(obj client.Object) ([]byte, error) {
 	annotations := obj.GetAnnotations()
-	annotations["mssql/db_id"] = a.DatabaseID
-	a.Logger.Info("value of annotations", "mssql/db_id", annotations["mssql/db_id"])
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[databaseIDAnnotation] = a.DatabaseID
+	a.Logger.Info("value of annotations", databaseIDAnnotation, annotations[databaseIDAnnotation])
 
 	obj.SetAnnotations(annotations)
 	return json.Marshal(obj)
@@ -74,11 +125,243 @@ func (r *DatabaseReconciler) updateDatabaseStatus(db *sqlmi.Database, status, da
 	return r.Status().Update(context.TODO(), db)
 }
 
-func (r *DatabaseReconciler) finalizeDatabase(ctx context.Context, db *sqlmi.Database, mssql *ms.MSSql) error {
-	if err := mssql.DeleteDatabase(ctx, db.Spec.Name); err != nil {
+// failDatabase records an error of the instance on the status and hands it back to the caller when retrying can help
+func (r *DatabaseReconciler) failDatabase(db *sqlmi.Database, err error) error {
+	meta.SetStatusCondition(&db.Status.Conditions, *sqlCondition(db.ErroredCondition(), err))
+	if uerr := r.updateDatabaseStatus(db, sqlmi.DatabaseConditionError, ""); uerr != nil {
+		r.Logger.Error(uerr, "failed to update Database status")
+	}
+	return retryable(err)
+}
+
+// adoptionRefusal explains why the adoption policy does not allow managing the existing database, empty when it does
+func (r *DatabaseReconciler) adoptionRefusal(ctx context.Context, db *sqlmi.Database, msSQL *ms.MSSql) (string, error) {
+	switch db.Spec.AdoptionPolicy {
+	case sqlmi.AdoptionAdopt:
+		return "", nil
+	case sqlmi.AdoptionAdoptIfEmpty:
+		empty, err := msSQL.DatabaseIsEmpty(ctx, db.Spec.Name)
+		if err != nil {
+			return "", err
+		}
+		if !empty {
+			return fmt.Sprintf("database: %s exists on the server and holds user objects, the adoption policy only adopts empty databases", db.Spec.Name), nil
+		}
+		return "", nil
+	}
+	return fmt.Sprintf("database: %s exists on the server but is not managed by the database controller, set the adoption policy to adopt it", db.Spec.Name), nil
+}
+
+// dropsDatabase reports whether the deletion policy drops the database along with the object
+func dropsDatabase(db *sqlmi.Database) bool {
+	return db.Spec.DeletionPolicy == sqlmi.DeletionDelete || db.Spec.DeletionPolicy == sqlmi.DeletionBackupThenDelete
+}
+
+func (r *DatabaseReconciler) operation(uid types.UID) *ms.BackupOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.finalBackups[uid]
+}
+
+func (r *DatabaseReconciler) track(uid types.UID, op *ms.BackupOperation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finalBackups == nil {
+		r.finalBackups = map[types.UID]*ms.BackupOperation{}
+	}
+	r.finalBackups[uid] = op
+}
+
+func (r *DatabaseReconciler) forget(uid types.UID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.finalBackups, uid)
+}
+
+// finalBackupParams the copy only backup taken of the database before it is dropped
+func finalBackupParams(ctx context.Context, db *sqlmi.Database, mssql *ms.MSSql) (*ms.BackupParams, error) {
+	file := fmt.Sprintf("%s-final-%s.bak", db.Spec.Name, time.Now().UTC().Format("20060102T150405Z"))
+	params := &ms.BackupParams{Type: ms.BackupFull, CopyOnly: true}
+	switch {
+	case db.Spec.FinalBackupDestination.URL != "":
+		params.URL = strings.TrimSuffix(db.Spec.FinalBackupDestination.URL, "/") + "/" + file
+	case db.Spec.FinalBackupDestination.Disk != "":
+		params.Disk = path.Join(db.Spec.FinalBackupDestination.Disk, file)
+	default:
+		dir, err := mssql.DefaultBackupPath(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if dir == "" {
+			return nil, fmt.Errorf("the instance has no default backup directory, set the final backup destination")
+		}
+		params.Disk = path.Join(dir, file)
+	}
+	return params, nil
+}
+
+// setFinalBackupRunning records the running final backup on the Database, nil clears it
+func (r *DatabaseReconciler) setFinalBackupRunning(ctx context.Context, db *sqlmi.Database, running *runningFinalBackup) error {
+	if running == nil {
+		delete(db.Annotations, finalBackupRunningAnnotation)
+		return r.Update(ctx, db)
+	}
+	b, err := json.Marshal(running)
+	if err != nil {
 		return err
 	}
-	return nil
+	if db.Annotations == nil {
+		db.Annotations = map[string]string{}
+	}
+	db.Annotations[finalBackupRunningAnnotation] = string(b)
+	return r.Update(ctx, db)
+}
+
+// finalBackup takes a copy only backup of the database in the background before it is dropped. It returns where
+// the backup was written once it finished, or a positive duration to check on it again after
+func (r *DatabaseReconciler) finalBackup(ctx context.Context, db *sqlmi.Database, mssql *ms.MSSql) (string, time.Duration, error) {
+	var running *runningFinalBackup
+	if value, ok := db.Annotations[finalBackupRunningAnnotation]; ok {
+		running = &runningFinalBackup{}
+		if err := json.Unmarshal([]byte(value), running); err != nil {
+			// a marker that cannot be read is dropped and the backup taken again
+			r.Logger.Error(err, "invalid final backup annotation", "annotation", value)
+			return "", 0, r.setFinalBackupRunning(ctx, db, nil)
+		}
+	}
+
+	op := r.operation(db.UID)
+	switch {
+	case running == nil:
+		params, err := finalBackupParams(ctx, db, mssql)
+		if err != nil {
+			return "", 0, err
+		}
+		op, err = mssql.StartBackup(ctx, db.Spec.Name, params)
+		if err != nil {
+			return "", 0, err
+		}
+		r.track(db.UID, op)
+		r.Recorder.Eventf(db, corev1.EventTypeNormal, "FinalBackupStarted", "final backup of database %s started", db.Spec.Name)
+		running = &runningFinalBackup{Disk: params.Disk, URL: params.URL, SessionID: op.SessionID, StartTime: time.Now()}
+		return "", backupPollInterval, r.setFinalBackupRunning(ctx, db, running)
+
+	case op != nil && !op.Done():
+		return "", backupPollInterval, nil
+
+	case op != nil:
+		r.forget(db.UID)
+		if _, err := op.Result(); err != nil {
+			// the next attempt takes the backup again
+			if uerr := r.setFinalBackupRunning(ctx, db, nil); uerr != nil {
+				r.Logger.Error(uerr, "failed to clear the final backup annotation")
+			}
+			return "", 0, err
+		}
+
+	default:
+		// this process did not start the backup, it kept running on the instance while the operator restarted
+		progress, err := mssql.BackupProgress(ctx, running.SessionID)
+		if err != nil {
+			return "", 0, err
+		}
+		if progress != nil {
+			return "", backupPollInterval, nil
+		}
+		params := &ms.BackupParams{Type: ms.BackupFull, CopyOnly: true, Disk: running.Disk, URL: running.URL}
+		// the instance clock runs in utc
+		result, err := mssql.BackupRecorded(ctx, db.Spec.Name, params, running.StartTime.UTC())
+		if err != nil {
+			return "", 0, err
+		}
+		if result == nil {
+			if err = r.setFinalBackupRunning(ctx, db, nil); err != nil {
+				return "", 0, err
+			}
+			return "", 0, fmt.Errorf("the final backup was interrupted by a restart of the operator, it is taken again")
+		}
+	}
+
+	if running.URL != "" {
+		return running.URL, 0, nil
+	}
+	return running.Disk, 0, nil
+}
+
+// blockingSessions describes the sessions keeping the database from being dropped
+func blockingSessions(sessions []ms.Session) string {
+	described := []string{}
+	for i, s := range sessions {
+		if i == 10 {
+			described = append(described, "...")
+			break
+		}
+		described = append(described, fmt.Sprintf("%d (login: %s, host: %s, program: %s, status: %s)", s.SessionID, s.LoginName, s.HostName, s.ProgramName, s.Status))
+	}
+	return fmt.Sprintf("%d session(s) connected to the database: %s", len(sessions), strings.Join(described, ", "))
+}
+
+// finalizeDatabase applies the deletion policy, it returns a positive duration when the drop waits for sessions to close
+func (r *DatabaseReconciler) finalizeDatabase(ctx context.Context, db *sqlmi.Database, mssql *ms.MSSql) (time.Duration, error) {
+	if db.Status.DatabaseID == "" {
+		// the database was never created or adopted by the controller, it is not ours to drop
+		return 0, nil
+	}
+
+	switch db.Spec.DeletionPolicy {
+	case sqlmi.DeletionDelete:
+	case sqlmi.DeletionBackupThenDelete:
+		// a backup taken by an earlier attempt is not taken again
+		if _, ok := db.Annotations[finalBackupAnnotation]; !ok {
+			location, wait, err := r.finalBackup(ctx, db, mssql)
+			if err != nil {
+				r.Recorder.Eventf(db, corev1.EventTypeWarning, "FinalBackupFailed", "final backup of database %s failed: %v", db.Spec.Name, err)
+				return 0, err
+			}
+			if wait > 0 {
+				return wait, nil
+			}
+			delete(db.Annotations, finalBackupRunningAnnotation)
+			db.Annotations[finalBackupAnnotation] = location
+			if err = r.Update(ctx, db); err != nil {
+				return 0, err
+			}
+			r.Recorder.Eventf(db, corev1.EventTypeNormal, "FinalBackup", "final backup of database %s written to %s", db.Spec.Name, location)
+		}
+	default:
+		return 0, nil
+	}
+
+	sessions, err := mssql.DatabaseSessions(ctx, db.Spec.Name)
+	if err != nil {
+		return 0, err
+	}
+	if len(sessions) == 0 {
+		err = mssql.DeleteDatabase(ctx, db.Spec.Name)
+	} else {
+		grace := db.Spec.SessionGracePeriodSeconds
+		waited := time.Since(db.DeletionTimestamp.Time)
+		if grace == nil || waited < time.Duration(*grace)*time.Second {
+			message := blockingSessions(sessions)
+			r.Logger.Info("waiting for sessions to close before dropping the database", "name", db.Spec.Name, "sessions", len(sessions))
+			r.Recorder.Event(db, corev1.EventTypeWarning, "DeletionBlocked", message)
+			meta.SetStatusCondition(&db.Status.Conditions, *db.DeletionBlockedCondition(message))
+			if err = r.Status().Update(ctx, db); err != nil {
+				return 0, err
+			}
+			if grace != nil && time.Duration(*grace)*time.Second-waited < sessionPollInterval {
+				return time.Duration(*grace)*time.Second - waited, nil
+			}
+			return sessionPollInterval, nil
+		}
+		r.Recorder.Eventf(db, corev1.EventTypeWarning, "SessionsRolledBack", "grace period expired, rolling back %s", blockingSessions(sessions))
+		err = mssql.RollbackAndDeleteDatabase(ctx, db.Spec.Name)
+	}
+	if err != nil {
+		return 0, err
+	}
+	r.Recorder.Eventf(db, corev1.EventTypeNormal, "Deleted", "database %s was dropped", db.Spec.Name)
+	return 0, nil
 }
 
 //+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=databases,verbs=get;list;watch;create;update;patch;delete
@@ -89,6 +372,10 @@ func (r *DatabaseReconciler) finalizeDatabase(ctx context.Context, db *sqlmi.Dat
 //+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=batch,resources=cronjobs/status,verbs=get
+//+kubebuilder:rbac:groups=core,resources=serviceaccounts,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=rolebindings,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles,verbs=bind,resourceNames=arc-sql-mi-cron-reader-role
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -112,42 +399,67 @@ func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
 			logger.Info("Database resource not found. Ignoring since object must be deleted")
-			return ctrl.Result{}, client.IgnoreNotFound(err)
+			// the sync service account of the namespace may have been kept for it alone
+			return ctrl.Result{}, r.releaseSyncServiceAccount(ctx, req.Namespace)
 		}
 		// Error reading the object - requeue the request.
 		logger.Error(err, "failed to get Database")
 		return ctrl.Result{}, err
 	}
 
+	// a retained database needs nothing from the instance, which may already be gone along with its namespace
+	if !db.ObjectMeta.DeletionTimestamp.IsZero() && (db.Status.DatabaseID == "" || !dropsDatabase(db)) {
+		if controllerutil.ContainsFinalizer(db, databaseFinalizer) {
+			if db.Status.DatabaseID != "" {
+				r.Recorder.Eventf(db, corev1.EventTypeNormal, "Retained", "database %s is retained on the instance", db.Spec.Name)
+			}
+			controllerutil.RemoveFinalizer(db, databaseFinalizer)
+			if err := r.Update(ctx, db); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
 	/*******************************************************************************************************************
 	* Quering the defined secret for the database connection
 	*******************************************************************************************************************/
-	mi, err := ms.QuerySQLManagedInstance(ctx, db.Namespace, db.Spec.SQLManagedInstance)
+	// the instance watch brings us back once the instance exists or becomes ready
+	mi, err := sqlManagedInstance(ctx, r.Client, db.Namespace, db.Spec.SQLManagedInstance)
 	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("waiting for the sql managed instance", "sql-managed-instance", db.Spec.SQLManagedInstance)
+			meta.SetStatusCondition(&db.Status.Conditions, *db.InstanceNotReadyCondition(fmt.Sprintf("the sql managed instance: %s does not exist", db.Spec.SQLManagedInstance)))
+			return ctrl.Result{}, r.updateDatabaseStatus(db, sqlmi.DatabaseConditionInstanceNotReady, "")
+		}
 		return ctrl.Result{}, err
 	}
 	logger.V(1).Info("successfully found managed instance", "sql-managed-instance", db.Spec.SQLManagedInstance)
 	if mi.Status.State != "Ready" {
-		meta.SetStatusCondition(&db.Status.Conditions, *db.ErroredCondition())
-		r.updateDatabaseStatus(db, "Error", "")
-		return ctrl.Result{}, fmt.Errorf("the sql managed instance is not in a `Ready` state, current status is: %v", mi.Status)
+		logger.Info("waiting for the sql managed instance", "sql-managed-instance", db.Spec.SQLManagedInstance, "state", mi.Status.State)
+		meta.SetStatusCondition(&db.Status.Conditions, *db.InstanceNotReadyCondition(fmt.Sprintf("the sql managed instance is not in a `Ready` state, current state is: %s", mi.Status.State)))
+		return ctrl.Result{}, r.updateDatabaseStatus(db, sqlmi.DatabaseConditionInstanceNotReady, "")
 	}
-	sec := &corev1.Secret{}
+	meta.RemoveStatusCondition(&db.Status.Conditions, sqlmi.DatabaseConditionInstanceNotReady)
+	/******************************************************************************************************************/
 
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: mi.Spec.LoginRef.Name, Namespace: mi.Spec.LoginRef.Namespace}, sec)
+	// This is the creating a MSSql Server `Provider`
+	// the secret watch brings us back once the credentials secret is created or changed
+	msSQL, secretVersion, err := databaseProvider(ctx, r.Client, r.Pool, db, mi, r.AllowInstanceAdminCredentials)
 	if err != nil {
-		logger.Error(err, "secrets credentials resource not found", "secret-name", mi.Spec.LoginRef.Name)
+		if _, ok := err.(*credentialsError); ok {
+			logger.Info("credentials unavailable", "reason", err.Error())
+			meta.SetStatusCondition(&db.Status.Conditions, *db.CredentialsUnavailableCondition(err.Error()))
+			return ctrl.Result{}, r.updateDatabaseStatus(db, sqlmi.DatabaseConditionCredentialsUnavailable, "")
+		}
+		logger.Error(err, "failed to connect to the sql managed instance")
 		return ctrl.Result{}, err
 	}
-
-	username := sec.Data["username"]
-	password := sec.Data["password"]
-	/******************************************************************************************************************/
-
-	// This is the creating a MSSql Server `Provider`
-	// db.Spec.Server
-	// msSQL := ms.NewMSSql(fmt.Sprintf("%s-p-svc", db.Spec.SQLManagedInstance), string(username), string(password), db.Spec.Port)
-	msSQL := ms.NewMSSql(db.Spec.Server, string(username), string(password), db.Spec.Port)
+	meta.RemoveStatusCondition(&db.Status.Conditions, sqlmi.DatabaseConditionCredentialsUnavailable)
+	if db.Status.CredentialsSecretVersion != secretVersion {
+		logger.Info("connecting with a new version of the credentials secret", "version", secretVersion)
+		db.Status.CredentialsSecretVersion = secretVersion
+	}
 	// Let's look at the status here first
 
 	/*******************************************************************************************************************
@@ -164,8 +476,12 @@ func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		}
 	} else {
 		if controllerutil.ContainsFinalizer(db, databaseFinalizer) {
-			if err = r.finalizeDatabase(ctx, db, msSQL); err != nil {
-				return ctrl.Result{}, err
+			wait, err := r.finalizeDatabase(ctx, db, msSQL)
+			if err != nil {
+				return ctrl.Result{}, r.failDatabase(db, err)
+			}
+			if wait > 0 {
+				return ctrl.Result{RequeueAfter: wait}, nil
 			}
 		}
 		controllerutil.RemoveFinalizer(db, databaseFinalizer)
@@ -181,6 +497,18 @@ func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	/*******************************************************************************************************************
 	* Let's do sync logic here...
 	/******************************************************************************************************************/
+	// a restored database is adopted through the id annotation, replacing the id of the database it overwrote
+	if id := db.Annotations[databaseIDAnnotation]; id != "" && id != db.Status.DatabaseID {
+		dn, err := msSQL.FindDatabaseName(ctx, id)
+		if err != nil {
+			return ctrl.Result{}, r.failDatabase(db, err)
+		}
+		if dn != nil && *dn == db.Spec.Name {
+			logger.Info("adopting existing database", "name", db.Spec.Name, "database-id", id)
+			db.Status.DatabaseID = id
+		}
+	}
+
 	status := "Pending"
 	condition := *db.PendingCondition()
 	var databaseId *string
@@ -188,94 +516,398 @@ func (r *DatabaseReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	databaseId = &db.Status.DatabaseID
 
 	if db.Status.DatabaseID == "" {
-		databaseId, err = msSQL.CreateDatabase(ctx, db.Spec.Name, &ms.DatabaseParams{Collation: ms.SetString(db.Spec.Collation),
-			AllowSnapshotIsolation:     &db.Spec.AllowSnapshotIsolation,
-			AllowReadCommittedSnapshot: &db.Spec.AllowReadCommittedSnapshot,
-			Parameterization:           &db.Spec.Parameterization,
-			CompatibilityLevel:         &db.Spec.CompatibilityLevel})
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-		condition = *db.CreatedCondition()
-		status = sqlmi.DatabaseConditionCreated
-	} else {
-		syncResponse, err := msSQL.SyncNeeded(ctx, &ms.DatabaseConfig{DatabaseName: db.Spec.Name, DatabaseID: db.Status.DatabaseID,
-			CompatibilityLevel:         db.Spec.CompatibilityLevel,
-			AllowSnapshotIsolation:     db.Spec.AllowSnapshotIsolation,
-			AllowReadCommittedSnapshot: db.Spec.AllowReadCommittedSnapshot,
-			Parameterization:           db.Spec.Parameterization}, ms.State)
+		databaseId, err = msSQL.FindDatabaseID(ctx, db.Spec.Name)
 		if err != nil {
-			return ctrl.Result{}, err
+			return ctrl.Result{}, r.failDatabase(db, err)
 		}
-		if syncResponse != nil {
-			err = msSQL.AlterDatabase(ctx, db.Spec.Name, &ms.DatabaseParams{
-				AllowSnapshotIsolation:     syncResponse.AllowSnapshotIsolation,
-				AllowReadCommittedSnapshot: syncResponse.AllowReadCommittedSnapshot,
-				Parameterization:           syncResponse.Parameterization,
-				CompatibilityLevel:         syncResponse.CompatibilityLevel})
+		if databaseId != nil {
+			refusal, err := r.adoptionRefusal(ctx, db, msSQL)
 			if err != nil {
-				return ctrl.Result{}, err
+				return ctrl.Result{}, r.failDatabase(db, err)
+			}
+			if refusal != "" {
+				// retrying does not help until the spec or the database changes
+				logger.Info("refusing to adopt existing database", "name", db.Spec.Name, "adoption-policy", db.Spec.AdoptionPolicy)
+				meta.SetStatusCondition(&db.Status.Conditions, *db.AdoptionRefusedCondition(refusal))
+				return ctrl.Result{}, r.updateDatabaseStatus(db, sqlmi.DatabaseConditionError, "")
 			}
+			logger.Info("adopting existing database", "name", db.Spec.Name, "database-id", *databaseId)
+			meta.RemoveStatusCondition(&db.Status.Conditions, sqlmi.DatabaseConditionError)
+			condition = *db.AdoptedCondition()
+			status = sqlmi.DatabaseConditionAdopted
+		} else {
+			var results []ms.AlterResult
+			databaseId, results, err = msSQL.CreateDatabase(ctx, db.Spec.Name, &ms.DatabaseParams{Collation: ms.SetString(db.Spec.Collation),
+				AllowSnapshotIsolation:     &db.Spec.AllowSnapshotIsolation,
+				AllowReadCommittedSnapshot: &db.Spec.AllowReadCommittedSnapshot,
+				Parameterization:           &db.Spec.Parameterization,
+				CompatibilityLevel:         &db.Spec.CompatibilityLevel})
+			r.recordSettings(db, results)
+			if err != nil {
+				// a database created with a setting that failed is kept, the next reconcile alters the setting again
+				if databaseId != nil {
+					db.Status.DatabaseID = *databaseId
+				}
+				return ctrl.Result{}, r.failDatabase(db, err)
+			}
+			condition = *db.CreatedCondition()
+			status = sqlmi.DatabaseConditionCreated
+		}
+	} else {
+		if err = r.syncDatabase(ctx, db, msSQL); err != nil {
+			return ctrl.Result{}, r.failDatabase(db, err)
 		}
 
 		condition = *db.SyncedCondition()
 		status = sqlmi.DatabaseConditionSynced
 	}
 
-	// Check if the cronjob already exists, if not create a new one
-	found := &batch.CronJob{}
-	err = r.Get(ctx, types.NamespacedName{Name: db.Name, Namespace: db.Namespace}, found)
-	if err != nil && errors.IsNotFound(err) {
-		// Define a new cronjob
-		dep, err := r.createSyncJob(db, mi, msSQL)
-		if err != nil {
-			logger.Error(err, "Failed to create new CronJob")
+	if db.Spec.SyncMode == sqlmi.SyncModeCronJob {
+		if refusal := syncJobRefusal(db, mi); refusal != "" {
+			// retrying does not help until the spec or the instance changes
+			logger.Info("refusing the CronJob sync mode", "name", db.Spec.Name)
+			if err = r.deleteSyncJob(ctx, db); err != nil {
+				return ctrl.Result{}, err
+			}
+			meta.SetStatusCondition(&db.Status.Conditions, *db.SyncJobRefusedCondition(refusal))
+			return ctrl.Result{}, r.updateDatabaseStatus(db, sqlmi.DatabaseConditionError, ms.SafeString(databaseId))
+		}
+		if err = r.ensureSyncServiceAccount(ctx, db); err != nil {
+			logger.Error(err, "Failed to create the service account of the CronJob", "CronJob.Namespace", db.Namespace)
+			return ctrl.Result{}, err
+		}
+		// Check if the cronjob already exists, if not create a new one
+		found := &batch.CronJob{}
+		err = r.Get(ctx, types.NamespacedName{Name: db.Name, Namespace: db.Namespace}, found)
+		if err != nil && errors.IsNotFound(err) {
+			// Define a new cronjob
+			dep, err := r.createSyncJob(db, mi)
+			if err != nil {
+				logger.Error(err, "Failed to create new CronJob")
+				return ctrl.Result{}, err
+			}
+			logger.Info("Creating a new CronJob", "CronJob.Namespace", dep.Namespace, "CronJob.Name", dep.Name)
+			err = r.Create(ctx, dep)
+			if err != nil {
+				logger.Error(err, "Failed to create new CronJob", "CronJob.Namespace", dep.Namespace, "CronJob.Name", dep.Name)
+				return ctrl.Result{}, err
+			}
+			if status == sqlmi.DatabaseConditionCreated || status == sqlmi.DatabaseConditionAdopted {
+				meta.SetStatusCondition(&db.Status.Conditions, condition)
+				if err = r.updateDatabaseStatus(db, status, ms.SafeString(databaseId)); err != nil {
+					return ctrl.Result{}, err
+				}
+			}
+			// CronJob created successfully - return and requeue
+			return ctrl.Result{Requeue: true}, nil
+		} else if err != nil {
+			logger.Error(err, "Failed to get CronJob")
+			return ctrl.Result{}, err
 		}
-		logger.Info("Creating a new CronJob", "CronJob.Namespace", dep.Namespace, "CronJob.Name", dep.Name)
-		err = r.Create(ctx, dep)
+
+		// Ensure the schedule and the job template are the same as the spec, CronJobs written by older versions
+		// passed the password in plain env variables and are replaced here
+		desired, err := r.createSyncJob(db, mi)
 		if err != nil {
-			logger.Error(err, "Failed to create new CronJob", "CronJob.Namespace", dep.Namespace, "CronJob.Name", dep.Name)
 			return ctrl.Result{}, err
 		}
-		if status == sqlmi.DatabaseConditionCreated {
-			meta.SetStatusCondition(&db.Status.Conditions, condition)
-			r.updateDatabaseStatus(db, status, ms.SafeString(databaseId))
+		if found.Spec.Schedule != desired.Spec.Schedule || found.Annotations[syncTemplateAnnotation] != desired.Annotations[syncTemplateAnnotation] {
+			logger.Info("Updating the CronJob", "CronJob.Namespace", found.Namespace, "CronJob.Name", found.Name)
+			found.Spec = desired.Spec
+			if found.Annotations == nil {
+				found.Annotations = map[string]string{}
+			}
+			found.Annotations[syncTemplateAnnotation] = desired.Annotations[syncTemplateAnnotation]
+			err = r.Update(ctx, found)
+			if err != nil {
+				logger.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
+				return ctrl.Result{}, err
+			}
+			// Spec updated - return and requeue
+			return ctrl.Result{Requeue: true}, nil
 		}
-		// CronJob created successfully - return and requeue
-		return ctrl.Result{Requeue: true}, nil
-	} else if err != nil {
-		logger.Error(err, "Failed to get CronJob")
+	} else if err := r.deleteSyncJob(ctx, db); err != nil {
 		return ctrl.Result{}, err
 	}
 
-	// Ensure the deployment size is the same as the spec
-	sched := db.Spec.Schedule
-	if sched == "" {
-		sched = defaultSchedule
+	if db.Spec.SyncMode == sqlmi.SyncModeCronJob {
+		meta.RemoveStatusCondition(&db.Status.Conditions, sqlmi.DatabaseConditionError)
+		meta.SetStatusCondition(&db.Status.Conditions, condition)
+		db.Status.NextSyncTime = nil
+		return ctrl.Result{}, r.updateDatabaseStatus(db, status, ms.SafeString(databaseId))
+	}
+
+	// the controller checks the database itself, coming back when the schedule fires next
+	next, err := r.nextSync(db)
+	if err != nil {
+		logger.Error(err, "invalid sync schedule", "schedule", db.Spec.Schedule)
+		errored := db.ErroredCondition()
+		errored.Message = err.Error()
+		meta.SetStatusCondition(&db.Status.Conditions, *errored)
+		return ctrl.Result{}, r.updateDatabaseStatus(db, sqlmi.DatabaseConditionError, ms.SafeString(databaseId))
+	}
+	meta.RemoveStatusCondition(&db.Status.Conditions, sqlmi.DatabaseConditionError)
+	meta.SetStatusCondition(&db.Status.Conditions, condition)
+	if err = r.updateDatabaseStatus(db, status, ms.SafeString(databaseId)); err != nil {
+		return ctrl.Result{}, err
 	}
-	if found.Spec.Schedule != sched {
-		found.Spec.Schedule = sched
-		err = r.Update(ctx, found)
+	if next.IsZero() {
+		return ctrl.Result{}, nil
+	}
+	return ctrl.Result{RequeueAfter: time.Until(next)}, nil
+}
+
+// syncDatabase compares the database on the instance with its spec and handles the settings that drifted the way
+// the drift policy says
+func (r *DatabaseReconciler) syncDatabase(ctx context.Context, db *sqlmi.Database, msSQL *ms.MSSql) error {
+	logger := r.Logger.WithValues("database", types.NamespacedName{Name: db.Name, Namespace: db.Namespace})
+	if db.Spec.DriftPolicy == sqlmi.DriftIgnore {
+		meta.RemoveStatusCondition(&db.Status.Conditions, sqlmi.DatabaseConditionDrifted)
+		db.Status.Drift = nil
+		return nil
+	}
+
+	config := &ms.DatabaseConfig{DatabaseName: db.Spec.Name, DatabaseID: db.Status.DatabaseID,
+		CompatibilityLevel:         db.Spec.CompatibilityLevel,
+		AllowSnapshotIsolation:     db.Spec.AllowSnapshotIsolation,
+		AllowReadCommittedSnapshot: db.Spec.AllowReadCommittedSnapshot,
+		Parameterization:           db.Spec.Parameterization}
+	// enforcing needs the values of the spec for the alter, the other policies the values found on the instance
+	var syncType ms.SyncType = ms.Database
+	if db.Spec.DriftPolicy == sqlmi.DriftEnforce || db.Spec.DriftPolicy == "" {
+		syncType = ms.State
+	}
+	syncResponse, err := msSQL.SyncNeeded(ctx, config, syncType)
+	if err != nil {
+		return err
+	}
+	now := metav1.Now()
+	db.Status.LastSyncTime = &now
+	db.Status.Drift = nil
+	if syncResponse == nil {
+		meta.SetStatusCondition(&db.Status.Conditions, *db.InSyncCondition())
+		return nil
+	}
+
+	switch db.Spec.DriftPolicy {
+	case sqlmi.DriftReport:
+		drift := ms.DatabaseDrift(config, syncResponse)
+		logger.Info("database drifted from its spec", "drift", drift)
+		message := "drifted settings: " + joinDrift(drift)
+		for _, d := range drift {
+			db.Status.Drift = append(db.Status.Drift, sqlmi.FieldDrift{Field: d.Field, Expected: d.Expected, Actual: d.Actual})
+		}
+		r.Recorder.Event(db, corev1.EventTypeWarning, "Drifted", message)
+		meta.SetStatusCondition(&db.Status.Conditions, *db.DriftDetectedCondition(message))
+	case sqlmi.DriftAdopt:
+		drift := ms.DatabaseDrift(config, syncResponse)
+		logger.Info("adopting the settings found on the instance into the spec", "drift", drift)
+		message := "adopted settings: " + joinDrift(drift)
+		adoptDrift(db, syncResponse)
+		// the update hands back the stored status, the changes made to it in this reconcile are kept
+		status := db.Status.DeepCopy()
+		if err = r.Update(ctx, db); err != nil {
+			return err
+		}
+		db.Status = *status
+		r.Recorder.Event(db, corev1.EventTypeNormal, "DriftAdopted", message)
+		meta.SetStatusCondition(&db.Status.Conditions, *db.DriftAdoptedCondition(message))
+	default:
+		drift := describeDrift(syncResponse)
+		logger.Info("database drifted from its spec", "settings", drift)
+		results, err := msSQL.AlterDatabase(ctx, db.Spec.Name, &ms.DatabaseParams{
+			AllowSnapshotIsolation:     syncResponse.AllowSnapshotIsolation,
+			AllowReadCommittedSnapshot: syncResponse.AllowReadCommittedSnapshot,
+			Parameterization:           syncResponse.Parameterization,
+			CompatibilityLevel:         syncResponse.CompatibilityLevel})
+		r.recordSettings(db, results)
 		if err != nil {
-			logger.Error(err, "Failed to update Deployment", "Deployment.Namespace", found.Namespace, "Deployment.Name", found.Name)
-			return ctrl.Result{}, err
+			return err
 		}
-		// Spec updated - return and requeue
-		return ctrl.Result{Requeue: true}, nil
+		r.Recorder.Eventf(db, corev1.EventTypeNormal, "Drifted", "corrected settings: %s", drift)
+		meta.SetStatusCondition(&db.Status.Conditions, *db.DriftedCondition("corrected settings: " + drift))
 	}
+	return nil
+}
 
-	meta.SetStatusCondition(&db.Status.Conditions, condition)
-	r.updateDatabaseStatus(db, status, ms.SafeString(databaseId))
+// recordSettings records the outcome of every setting the controller altered in the status, replacing the outcome
+// of the last change of the same setting, with a warning event for each setting that failed
+func (r *DatabaseReconciler) recordSettings(db *sqlmi.Database, results []ms.AlterResult) {
+	now := metav1.Now()
+	for _, result := range results {
+		setting := sqlmi.SettingStatus{Field: result.Field, Value: result.Value, State: sqlmi.SettingApplied, LastAttemptTime: now}
+		if result.Err != nil {
+			classified := ms.ClassifyError(result.Err)
+			setting.State = sqlmi.SettingFailed
+			setting.ErrorNumber = classified.Number
+			setting.Message = classified.Message
+			r.Recorder.Eventf(db, corev1.EventTypeWarning, "SettingFailed", "setting %s to %s failed: %s",
+				result.Field, result.Value, ms.ErrorMessage(result.Err))
+		}
+		recorded := false
+		for i := range db.Status.Settings {
+			if db.Status.Settings[i].Field == setting.Field {
+				db.Status.Settings[i] = setting
+				recorded = true
+			}
+		}
+		if !recorded {
+			db.Status.Settings = append(db.Status.Settings, setting)
+		}
+	}
+}
+
+// adoptDrift writes the values found on the instance into the spec
+func adoptDrift(db *sqlmi.Database, actual *ms.SyncResponse) {
+	if actual.CompatibilityLevel != nil {
+		db.Spec.CompatibilityLevel = *actual.CompatibilityLevel
+	}
+	if actual.AllowSnapshotIsolation != nil {
+		db.Spec.AllowSnapshotIsolation = *actual.AllowSnapshotIsolation
+	}
+	if actual.AllowReadCommittedSnapshot != nil {
+		db.Spec.AllowReadCommittedSnapshot = *actual.AllowReadCommittedSnapshot
+	}
+	if actual.Parameterization != nil {
+		db.Spec.Parameterization = *actual.Parameterization
+	}
+}
+
+func joinDrift(drift []ms.FieldDrift) string {
+	fields := make([]string, len(drift))
+	for i, d := range drift {
+		fields[i] = d.String()
+	}
+	return strings.Join(fields, "; ")
+}
+
+// nextSync when the schedule of the database fires next, recorded in the status
+func (r *DatabaseReconciler) nextSync(db *sqlmi.Database) (time.Time, error) {
+	spec := db.Spec.Schedule
+	if spec == "" {
+		spec = defaultSchedule
+	}
+	schedule, err := ms.ParseSchedule(spec)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := schedule.Next(time.Now())
+	db.Status.NextSyncTime = nil
+	if !next.IsZero() {
+		db.Status.NextSyncTime = &metav1.Time{Time: next}
+	}
+	return next, nil
+}
+
+// deleteSyncJob removes the sync CronJob left behind by the CronJob sync mode, along with the sync service account
+// of the namespace when no other Database needs it
+func (r *DatabaseReconciler) deleteSyncJob(ctx context.Context, db *sqlmi.Database) error {
+	job := &batch.CronJob{}
+	err := r.Get(ctx, types.NamespacedName{Name: db.Name, Namespace: db.Namespace}, job)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && metav1.IsControlledBy(job, db) {
+		r.Logger.Info("Deleting the CronJob of the CronJob sync mode", "CronJob.Namespace", job.Namespace, "CronJob.Name", job.Name)
+		if err = r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return r.releaseSyncServiceAccount(ctx, db.Namespace)
+}
 
-	return ctrl.Result{}, nil
+// describeDrift lists the settings a sync found different from the spec along with their values in the spec
+func describeDrift(params *ms.SyncResponse) string {
+	var settings []string
+	if params.AllowSnapshotIsolation != nil {
+		settings = append(settings, fmt.Sprintf("allowSnapshotIsolation=%t", *params.AllowSnapshotIsolation))
+	}
+	if params.AllowReadCommittedSnapshot != nil {
+		settings = append(settings, fmt.Sprintf("allowReadCommittedSnapshot=%t", *params.AllowReadCommittedSnapshot))
+	}
+	if params.Parameterization != nil {
+		settings = append(settings, fmt.Sprintf("parameterization=%s", *params.Parameterization))
+	}
+	if params.CompatibilityLevel != nil {
+		settings = append(settings, fmt.Sprintf("compatibilityLevel=%d", *params.CompatibilityLevel))
+	}
+	return strings.Join(settings, ", ")
 }
 
 var (
 	jobOwnerKey = ".metadata.controller"
-	apiGVStr    = sqlmi.GroupVersion.String()
+	// sqlManagedInstanceField indexes Databases, Logins and Restores by the instance they are created on
+	sqlManagedInstanceField = ".spec.sqlManagedInstance"
+	// credentialsSecretField indexes Databases by the secret holding their credentials
+	credentialsSecretField = ".spec.credentials.name"
+	// passwordSecretField indexes Logins by the secret holding their password
+	passwordSecretField = ".spec.passwordSecret.name"
+	apiGVStr            = sqlmi.GroupVersion.String()
 )
 
-func (r *DatabaseReconciler) createSyncJob(db *sqlmi.Database, mi *ms.SQLManagedInstance, msSQL *ms.MSSql) (*batch.CronJob, error) {
+// syncCredentialsPath where the sync job finds the login it connects with
+const syncCredentialsPath = "/etc/sync/credentials"
+
+// syncTemplateAnnotation records the hash of the job template a sync CronJob was last written with
+const syncTemplateAnnotation = "sqlmi.arc-sql-mi.microsoft.io/template-hash"
+
+// credentialsVersionAnnotation records on the job template the version of the credentials secret, so a rotation
+// rolls out to the CronJob
+const credentialsVersionAnnotation = "sqlmi.arc-sql-mi.microsoft.io/credentials-version"
+
+// syncJobRefusal explains why the CronJob sync mode cannot run for the database, empty when it can.  The job only
+// reads secrets in the namespace of the Database, the admin secret of an instance kept in another namespace is out
+// of its reach
+func syncJobRefusal(db *sqlmi.Database, mi *ms.SQLManagedInstance) string {
+	if ref := db.Spec.Credentials; ref != nil && ref.Name != "" {
+		return ""
+	}
+	if key := loginRefKey(mi); key.Namespace != db.Namespace {
+		return fmt.Sprintf("the CronJob sync mode cannot read the admin login of the instance in namespace %s, name credentials in namespace %s or use the Controller sync mode",
+			key.Namespace, db.Namespace)
+	}
+	return ""
+}
+
+// syncCredentials hands the login the database is provisioned with to the sync job as files mounted from its
+// secret, so no password ends up in the CronJob
+func syncCredentials(db *sqlmi.Database, mi *ms.SQLManagedInstance) ([]corev1.EnvVar, []corev1.Volume, []corev1.VolumeMount) {
+	name, usernameKey, passwordKey := mi.Spec.LoginRef.Name, "username", "password"
+	if ref := db.Spec.Credentials; ref != nil && ref.Name != "" {
+		name = ref.Name
+		if ref.UsernameKey != "" {
+			usernameKey = ref.UsernameKey
+		}
+		if ref.PasswordKey != "" {
+			passwordKey = ref.PasswordKey
+		}
+	}
+
+	env := []corev1.EnvVar{
+		{Name: "DATABASE_USER_FILE", Value: path.Join(syncCredentialsPath, "username")},
+		{Name: "DATABASE_PASSWORD_FILE", Value: path.Join(syncCredentialsPath, "password")},
+	}
+	volumes := []corev1.Volume{{
+		Name: "credentials",
+		VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{
+			SecretName: name,
+			Items:      []corev1.KeyToPath{{Key: usernameKey, Path: "username"}, {Key: passwordKey, Path: "password"}},
+		}},
+	}}
+	mounts := []corev1.VolumeMount{{Name: "credentials", MountPath: syncCredentialsPath, ReadOnly: true}}
+	return env, volumes, mounts
+}
+
+// syncTemplateHash identifies the CronJob spec, CronJobs written with another spec are replaced
+func syncTemplateHash(spec *batch.CronJobSpec) (string, error) {
+	b, err := json.Marshal(spec)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))[:16], nil
+}
+
+func (r *DatabaseReconciler) createSyncJob(db *sqlmi.Database, mi *ms.SQLManagedInstance) (*batch.CronJob, error) {
 	// We want job names for a given nominal start time to have a deterministic name to avoid the same job being created twice
 	// sched := time.Now()
 	// name := fmt.Sprintf("%s-%d", db.Name, sched.Unix())
@@ -304,11 +936,9 @@ func (r *DatabaseReconciler) createSyncJob(db *sqlmi.Database, mi *ms.SQLManaged
 				Spec: batch.JobSpec{
 					Template: corev1.PodTemplateSpec{
 						Spec: corev1.PodSpec{
-							ServiceAccountName: "",
 							Containers: []corev1.Container{
 								{
-									Name:  "sync",
-									Image: "paulplavetzki/sync:v0.0.13",
+									Name: "sync",
 									Env: []corev1.EnvVar{
 										{
 											Name:  "DATABASE_CRD",
@@ -318,18 +948,6 @@ func (r *DatabaseReconciler) createSyncJob(db *sqlmi.Database, mi *ms.SQLManaged
 											Name:  "NAMESPACE",
 											Value: db.Namespace,
 										},
-										{
-											Name:  "DATABASE_PASSWORD",
-											Value: msSQL.Password,
-										},
-										{
-											Name:  "DATABASE_USER",
-											Value: msSQL.User,
-										},
-										{
-											Name:  "DATABASE_PORT",
-											Value: fmt.Sprintf("%d", msSQL.Port),
-										},
 										// {
 										// 	Name: "NAMESPACE",
 										// 	ValueFrom: &corev1.EnvVarSource{
@@ -362,6 +980,21 @@ func (r *DatabaseReconciler) createSyncJob(db *sqlmi.Database, mi *ms.SQLManaged
 	// for k, v := range cronJob.Spec.JobTemplate.Labels {
 	// 	job.Labels[k] = v
 	// }
+	env, volumes, mounts := syncCredentials(db, mi)
+	pod := &job.Spec.JobTemplate.Spec.Template.Spec
+	pod.Containers[0].Env = append(pod.Containers[0].Env, env...)
+	pod.Containers[0].VolumeMounts = mounts
+	pod.Volumes = volumes
+	job.Spec.JobTemplate.Spec.Template.Annotations = map[string]string{credentialsVersionAnnotation: db.Status.CredentialsSecretVersion}
+
+	applySyncJobTemplate(job, mergeSyncJobTemplate(r.SyncJobTemplate, db.Spec.SyncJobTemplate))
+
+	hash, err := syncTemplateHash(&job.Spec)
+	if err != nil {
+		return nil, err
+	}
+	job.Annotations[syncTemplateAnnotation] = hash
+
 	if err := ctrl.SetControllerReference(db, job, r.Scheme); err != nil {
 		return nil, err
 	}
@@ -371,6 +1004,12 @@ func (r *DatabaseReconciler) createSyncJob(db *sqlmi.Database, mi *ms.SQLManaged
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *DatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Pool == nil {
+		r.Pool = ms.NewPool(ms.DefaultPoolOptions())
+	}
+	if r.SyncJobTemplate == nil {
+		r.SyncJobTemplate = defaultSyncJobTemplate()
+	}
 
 	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &batch.Job{}, jobOwnerKey, func(rawObj client.Object) []string {
 		// grab the job object, extract the owner...
@@ -390,8 +1029,99 @@ func (r *DatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Database{}, sqlManagedInstanceField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.Database).Spec.SQLManagedInstance}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Database{}, credentialsSecretField, func(rawObj client.Object) []string {
+		db := rawObj.(*sqlmi.Database)
+		if db.Spec.Credentials == nil || db.Spec.Credentials.Name == "" {
+			return nil
+		}
+		return []string{db.Spec.Credentials.Name}
+	}); err != nil {
+		return err
+	}
+
+	// the status written by every pass would otherwise trigger the next one, leaving the schedule of the sync no say
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&sqlmi.Database{}).
+		For(&sqlmi.Database{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{},
+			predicate.AnnotationChangedPredicate{}, driftChangedPredicate()))).
 		Owns(&batch.CronJob{}).
+		Watches(&source.Kind{Type: &ms.SQLManagedInstance{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForInstance)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForSecret)).
 		Complete(r)
 }
+
+// requestsForInstance maps a sql managed instance to the databases created on it
+func (r *DatabaseReconciler) requestsForInstance(obj client.Object) []reconcile.Request {
+	dbs := &sqlmi.DatabaseList{}
+	if err := r.List(context.Background(), dbs, client.InNamespace(obj.GetNamespace()), client.MatchingFields{sqlManagedInstanceField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list Databases for SQLManagedInstance", "sql-managed-instance", obj.GetName())
+		return nil
+	}
+	return databaseRequests(dbs.Items)
+}
+
+// requestsForSecret maps a secret to the databases connecting with it, either through their credentials or, as the
+// admin login, through the instance they are created on
+func (r *DatabaseReconciler) requestsForSecret(obj client.Object) []reconcile.Request {
+	dbs := &sqlmi.DatabaseList{}
+	if err := r.List(context.Background(), dbs, client.InNamespace(obj.GetNamespace()), client.MatchingFields{credentialsSecretField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list Databases for Secret", "secret", obj.GetName())
+		return nil
+	}
+	requests := databaseRequests(dbs.Items)
+
+	instances := &ms.SQLManagedInstanceList{}
+	if err := r.List(context.Background(), instances); err != nil {
+		r.Logger.Error(err, "failed to list SQLManagedInstances for Secret", "secret", obj.GetName())
+		return requests
+	}
+	for i := range instances.Items {
+		mi := &instances.Items[i]
+		if loginRefKey(mi) != (types.NamespacedName{Name: obj.GetName(), Namespace: obj.GetNamespace()}) {
+			continue
+		}
+		dbs := &sqlmi.DatabaseList{}
+		if err := r.List(context.Background(), dbs, client.InNamespace(mi.Namespace), client.MatchingFields{sqlManagedInstanceField: mi.Name}); err != nil {
+			r.Logger.Error(err, "failed to list Databases for SQLManagedInstance", "sql-managed-instance", mi.Name)
+			continue
+		}
+		for _, db := range dbs.Items {
+			// databases with their own credentials do not connect with the admin login
+			if db.Spec.Credentials == nil || db.Spec.Credentials.Name == "" {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: db.Name, Namespace: db.Namespace}})
+			}
+		}
+	}
+	return requests
+}
+
+// driftChangedPredicate passes the drift a sync CronJob patches onto the status, so the controller applies the drift
+// policy to it
+func driftChangedPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old, ok := e.ObjectOld.(*sqlmi.Database)
+			if !ok {
+				return false
+			}
+			updated, ok := e.ObjectNew.(*sqlmi.Database)
+			if !ok {
+				return false
+			}
+			return !equality.Semantic.DeepEqual(old.Status.Drift, updated.Status.Drift)
+		},
+	}
+}
+
+func databaseRequests(dbs []sqlmi.Database) []reconcile.Request {
+	requests := make([]reconcile.Request, len(dbs))
+	for i, db := range dbs {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: db.Name, Namespace: db.Namespace}}
+	}
+	return requests
+}
@@ -0,0 +1,352 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// permissionResyncInterval how often the declared permissions are compared with the database
+const permissionResyncInterval = 10 * time.Minute
+
+// DatabasePermissionReconciler reconciles a DatabasePermission object
+type DatabasePermissionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger logr.Logger
+	// AllowInstanceAdminCredentials grants permissions in databases without credentials with the admin login of the instance
+	AllowInstanceAdminCredentials bool
+	// Pool shares the connections to the instances between reconciles
+	Pool *ms.Pool
+}
+
+func (r *DatabasePermissionReconciler) updatePermissionStatus(ctx context.Context, perm *sqlmi.DatabasePermission, status string, conditions ...*metav1.Condition) error {
+	perm.Status.Status = status
+	for _, condition := range conditions {
+		meta.SetStatusCondition(&perm.Status.Conditions, *condition)
+	}
+	if status != sqlmi.DatabasePermissionConditionError {
+		meta.RemoveStatusCondition(&perm.Status.Conditions, sqlmi.DatabasePermissionConditionError)
+	}
+	return r.Status().Update(ctx, perm)
+}
+
+// failPermission records the error on the permission status and hands it back to the caller when retrying can help
+func (r *DatabasePermissionReconciler) failPermission(ctx context.Context, perm *sqlmi.DatabasePermission, err error) error {
+	if uerr := r.updatePermissionStatus(ctx, perm, sqlmi.DatabasePermissionConditionError, sqlCondition(perm.ErroredCondition(err.Error()), err)); uerr != nil {
+		r.Logger.Error(uerr, "failed to update DatabasePermission status")
+	}
+	return retryable(err)
+}
+
+// finalizePermission revokes the managed permissions and drops the roles the controller created
+func (r *DatabasePermissionReconciler) finalizePermission(ctx context.Context, perm *sqlmi.DatabasePermission) error {
+	if perm.Status.DatabaseID == "" {
+		return nil
+	}
+	db, msSQL, databaseName, err := connectToDatabase(ctx, r.Client, r.Pool, perm.Namespace, perm.Spec.DatabaseRef, r.AllowInstanceAdminCredentials)
+	if err != nil {
+		if errors.IsNotFound(err) || err == errDatabasePending || (db != nil && !db.DeletionTimestamp.IsZero()) {
+			return nil
+		}
+		return err
+	}
+	if db.Status.DatabaseID != perm.Status.DatabaseID {
+		return nil
+	}
+	syncResponse, err := msSQL.PermissionSyncNeeded(ctx, &ms.PermissionConfig{
+		DatabaseName:       databaseName,
+		ManagedMemberships: managedMemberships(perm),
+		Principals:         perm.Status.Principals,
+	})
+	if err != nil {
+		return err
+	}
+	if syncResponse != nil {
+		if err = msSQL.ApplyPermissions(ctx, databaseName, syncResponse); err != nil {
+			return err
+		}
+	}
+	return msSQL.DropRoles(ctx, databaseName, perm.Status.Roles)
+}
+
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=databasepermissions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=databasepermissions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=databasepermissions/finalizers,verbs=update
+
+// Reconcile converges the roles, role members and permissions of a Database to the ones declared
+// by a DatabasePermission object
+func (r *DatabasePermissionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	logger := r.Logger.WithValues("databasepermission", req.NamespacedName)
+	logger.Info("reconciling database permission")
+
+	perm := &sqlmi.DatabasePermission{}
+	err := r.Get(ctx, req.NamespacedName, perm)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("DatabasePermission resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get DatabasePermission")
+		return ctrl.Result{}, err
+	}
+
+	/*******************************************************************************************************************
+	* Finalizer to check what to do if we're deleting the resource
+	*******************************************************************************************************************/
+	if perm.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(perm, databaseFinalizer) {
+			controllerutil.AddFinalizer(perm, databaseFinalizer)
+			if err = r.Update(ctx, perm); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(perm, databaseFinalizer) {
+			if err = r.finalizePermission(ctx, perm); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(perm, databaseFinalizer)
+		if err := r.Update(ctx, perm); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	/******************************************************************************************************************/
+
+	params, err := permissionConfig(perm)
+	if err != nil {
+		return ctrl.Result{}, r.failPermission(ctx, perm, err)
+	}
+
+	db, msSQL, databaseName, err := connectToDatabase(ctx, r.Client, r.Pool, perm.Namespace, perm.Spec.DatabaseRef, r.AllowInstanceAdminCredentials)
+	if err != nil {
+		if errors.IsNotFound(err) || err == errDatabasePending {
+			logger.Info("waiting for the referenced database", "database", perm.Spec.DatabaseRef)
+			return ctrl.Result{}, r.updatePermissionStatus(ctx, perm, sqlmi.DatabasePermissionConditionPending, perm.PendingCondition())
+		}
+		return ctrl.Result{}, r.failPermission(ctx, perm, err)
+	}
+	params.DatabaseName = databaseName
+
+	if perm.Status.DatabaseID != db.Status.DatabaseID {
+		// first reconcile, or the database was replaced along with everything the controller created
+		perm.Status.Roles = nil
+		perm.Status.Memberships = nil
+		perm.Status.Principals = nil
+		perm.Status.DatabaseID = db.Status.DatabaseID
+	}
+	params.ManagedMemberships = managedMemberships(perm)
+
+	// principals dropped from the spec are still compared so their permissions are revoked
+	params.Principals = union(params.Principals, perm.Status.Principals)
+
+	declaredRoles := map[string]bool{}
+	for _, role := range params.Roles {
+		declaredRoles[role] = true
+	}
+	createdRoles := []string{}
+	removedRoles := []string{}
+	for _, role := range perm.Status.Roles {
+		if declaredRoles[role] {
+			createdRoles = append(createdRoles, role)
+		} else {
+			removedRoles = append(removedRoles, role)
+		}
+	}
+	if err = msSQL.DropRoles(ctx, databaseName, removedRoles); err != nil {
+		return ctrl.Result{}, r.failPermission(ctx, perm, err)
+	}
+
+	syncResponse, err := msSQL.PermissionSyncNeeded(ctx, params)
+	if err != nil {
+		return ctrl.Result{}, r.failPermission(ctx, perm, err)
+	}
+	drift := []string{}
+	if syncResponse != nil {
+		drift = syncResponse.Statements()
+		logger.Info("database permissions drifted from the declared state", "statements", drift)
+		if err = msSQL.ApplyPermissions(ctx, databaseName, syncResponse); err != nil {
+			return ctrl.Result{}, r.failPermission(ctx, perm, err)
+		}
+		createdRoles = append(createdRoles, syncResponse.CreateRoles...)
+	}
+
+	now := metav1.Now()
+	perm.Status.Roles = createdRoles
+	perm.Status.Memberships = grantedMemberships(params, syncResponse)
+	perm.Status.Principals = principalsOf(perm)
+	perm.Status.Drift = drift
+	perm.Status.LastSyncTime = &now
+	if err = r.updatePermissionStatus(ctx, perm, sqlmi.DatabasePermissionConditionSynced, perm.SyncedCondition(), perm.DriftedCondition(drift)); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: permissionResyncInterval}, nil
+}
+
+// permissionConfig translates the spec into the desired config of the provider
+func permissionConfig(perm *sqlmi.DatabasePermission) (*ms.PermissionConfig, error) {
+	params := &ms.PermissionConfig{Principals: principalsOf(perm)}
+	for _, role := range perm.Spec.Roles {
+		params.Roles = append(params.Roles, role.Name)
+		for _, member := range role.Members {
+			params.Memberships = append(params.Memberships, ms.RoleMembership{Role: role.Name, Member: member})
+		}
+	}
+	for _, rule := range perm.Spec.Permissions {
+		state := "GRANT"
+		if rule.State == sqlmi.PermissionDeny {
+			state = "DENY"
+		}
+		class := "DATABASE"
+		securable := ""
+		switch rule.SecurableClass {
+		case sqlmi.SecurableSchema:
+			class = "SCHEMA"
+			securable = rule.Securable
+		case sqlmi.SecurableObject:
+			class = "OBJECT"
+			securable = rule.Securable
+			if !strings.Contains(securable, ".") {
+				securable = "dbo." + securable
+			}
+		}
+		for _, name := range rule.Permissions {
+			permission, err := ms.ValidatePermission(name)
+			if err != nil {
+				return nil, err
+			}
+			params.Permissions = append(params.Permissions, ms.Permission{
+				Principal:  rule.Principal,
+				State:      state,
+				Permission: permission,
+				Class:      class,
+				Securable:  securable,
+			})
+		}
+	}
+	return params, nil
+}
+
+// managedMemberships the role memberships the controller granted before, members added by others are never dropped
+func managedMemberships(perm *sqlmi.DatabasePermission) []ms.RoleMembership {
+	memberships := make([]ms.RoleMembership, len(perm.Status.Memberships))
+	for i, m := range perm.Status.Memberships {
+		memberships[i] = ms.RoleMembership{Role: m.Role, Member: m.Member}
+	}
+	return memberships
+}
+
+// grantedMemberships the declared memberships the controller granted, on this sync or before, a declared member
+// that was already in the role was added by someone else and is left to them
+func grantedMemberships(params *ms.PermissionConfig, syncResponse *ms.PermissionSyncResponse) []sqlmi.RoleMembership {
+	granted := map[ms.RoleMembership]bool{}
+	for _, m := range params.ManagedMemberships {
+		granted[m] = true
+	}
+	if syncResponse != nil {
+		for _, m := range syncResponse.AddMembers {
+			granted[m] = true
+		}
+	}
+	var memberships []sqlmi.RoleMembership
+	for _, m := range params.Memberships {
+		if granted[m] {
+			memberships = append(memberships, sqlmi.RoleMembership{Role: m.Role, Member: m.Member})
+		}
+	}
+	return memberships
+}
+
+func principalsOf(perm *sqlmi.DatabasePermission) []string {
+	principals := []string{}
+	for _, rule := range perm.Spec.Permissions {
+		principals = union(principals, []string{rule.Principal})
+	}
+	return principals
+}
+
+// union appends the values of b missing from a
+func union(a, b []string) []string {
+	seen := map[string]bool{}
+	for _, v := range a {
+		seen[v] = true
+	}
+	for _, v := range b {
+		if !seen[v] {
+			seen[v] = true
+			a = append(a, v)
+		}
+	}
+	return a
+}
+
+// requestsForDatabase maps a Database to the permissions referencing it
+func (r *DatabasePermissionReconciler) requestsForDatabase(obj client.Object) []reconcile.Request {
+	perms := &sqlmi.DatabasePermissionList{}
+	if err := r.List(context.Background(), perms, client.InNamespace(obj.GetNamespace()), client.MatchingFields{databaseRefField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list DatabasePermissions for Database", "database", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, len(perms.Items))
+	for i, perm := range perms.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: perm.Name, Namespace: perm.Namespace}}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabasePermissionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Pool == nil {
+		r.Pool = ms.NewPool(ms.DefaultPoolOptions())
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.DatabasePermission{}, databaseRefField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.DatabasePermission).Spec.DatabaseRef}
+	}); err != nil {
+		return err
+	}
+
+	// the status written by every pass would otherwise trigger the next one instead of the resync interval
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&sqlmi.DatabasePermission{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&source.Kind{Type: &sqlmi.Database{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForDatabase)).
+		Complete(r)
+}
@@ -0,0 +1,277 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const databaseRefField = ".spec.databaseRef"
+
+// DatabaseUserReconciler reconciles a DatabaseUser object
+type DatabaseUserReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger logr.Logger
+	// AllowInstanceAdminCredentials manages users in databases without credentials with the admin login of the instance
+	AllowInstanceAdminCredentials bool
+	// Pool shares the connections to the instances between reconciles
+	Pool *ms.Pool
+}
+
+func (r *DatabaseUserReconciler) updateUserStatus(ctx context.Context, user *sqlmi.DatabaseUser, status string, condition *metav1.Condition) error {
+	user.Status.Status = status
+	if condition != nil {
+		meta.SetStatusCondition(&user.Status.Conditions, *condition)
+	}
+	if status != sqlmi.DatabaseUserConditionError {
+		meta.RemoveStatusCondition(&user.Status.Conditions, sqlmi.DatabaseUserConditionError)
+	}
+	return r.Status().Update(ctx, user)
+}
+
+// failUser records the error on the user status and hands it back to the caller when retrying can help
+func (r *DatabaseUserReconciler) failUser(ctx context.Context, user *sqlmi.DatabaseUser, err error) error {
+	if uerr := r.updateUserStatus(ctx, user, sqlmi.DatabaseUserConditionError, sqlCondition(user.ErroredCondition(err.Error()), err)); uerr != nil {
+		r.Logger.Error(uerr, "failed to update DatabaseUser status")
+	}
+	return retryable(err)
+}
+
+func (r *DatabaseUserReconciler) finalizeUser(ctx context.Context, user *sqlmi.DatabaseUser) error {
+	if user.Status.SID == "" {
+		return nil
+	}
+	db, msSQL, databaseName, err := connectToDatabase(ctx, r.Client, r.Pool, user.Namespace, user.Spec.DatabaseRef, r.AllowInstanceAdminCredentials)
+	if err != nil {
+		// the database, and the user along with it, is gone or being replaced
+		if errors.IsNotFound(err) || err == errDatabasePending || (db != nil && !db.DeletionTimestamp.IsZero()) {
+			return nil
+		}
+		return err
+	}
+	if db.Status.DatabaseID != user.Status.DatabaseID {
+		return nil
+	}
+	return msSQL.DeleteUser(ctx, databaseName, user.Spec.Name)
+}
+
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=databaseusers,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=databaseusers/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=databaseusers/finalizers,verbs=update
+
+// Reconcile creates, alters and drops the user described by a DatabaseUser object inside its Database
+func (r *DatabaseUserReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	logger := r.Logger.WithValues("databaseuser", req.NamespacedName)
+	logger.Info("reconciling database user")
+
+	user := &sqlmi.DatabaseUser{}
+	err := r.Get(ctx, req.NamespacedName, user)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("DatabaseUser resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get DatabaseUser")
+		return ctrl.Result{}, err
+	}
+
+	/*******************************************************************************************************************
+	* Finalizer to check what to do if we're deleting the resource
+	*******************************************************************************************************************/
+	if user.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(user, databaseFinalizer) {
+			controllerutil.AddFinalizer(user, databaseFinalizer)
+			if err = r.Update(ctx, user); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(user, databaseFinalizer) {
+			if err = r.finalizeUser(ctx, user); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(user, databaseFinalizer)
+		if err := r.Update(ctx, user); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	/******************************************************************************************************************/
+
+	if (user.Spec.LoginName == "") == (user.Spec.PasswordSecret == nil) {
+		return ctrl.Result{}, r.failUser(ctx, user, ms.Errorf(ms.ErrorClassInvalidRequest, "exactly one of loginName or passwordSecret must be set"))
+	}
+
+	db, msSQL, databaseName, err := connectToDatabase(ctx, r.Client, r.Pool, user.Namespace, user.Spec.DatabaseRef, r.AllowInstanceAdminCredentials)
+	if err != nil {
+		if errors.IsNotFound(err) || err == errDatabasePending {
+			// the Database watch brings us back once the database exists
+			logger.Info("waiting for the referenced database", "database", user.Spec.DatabaseRef)
+			return ctrl.Result{}, r.updateUserStatus(ctx, user, sqlmi.DatabaseUserConditionPending, user.PendingCondition())
+		}
+		return ctrl.Result{}, r.failUser(ctx, user, err)
+	}
+
+	params := &ms.UserParams{
+		LoginName:     ms.SetString(user.Spec.LoginName),
+		DefaultSchema: ms.SetString(user.Spec.DefaultSchema),
+	}
+	secretVersion := ""
+	if user.Spec.PasswordSecret != nil {
+		password, version, err := secretPassword(ctx, r.Client, user.Namespace, *user.Spec.PasswordSecret)
+		if err != nil {
+			return ctrl.Result{}, r.failUser(ctx, user, err)
+		}
+		params.Password = &password
+		secretVersion = version
+	}
+
+	if user.Status.DatabaseID != db.Status.DatabaseID {
+		// first reconcile, or the database was replaced underneath the user
+		user.Status.SID = ""
+		user.Status.Roles = nil
+	}
+
+	current, err := msSQL.FindUser(ctx, databaseName, user.Spec.Name)
+	if err != nil {
+		return ctrl.Result{}, r.failUser(ctx, user, err)
+	}
+
+	status := sqlmi.DatabaseUserConditionSynced
+	condition := user.SyncedCondition()
+	if user.Status.SID == "" {
+		if current != nil {
+			return ctrl.Result{}, r.failUser(ctx, user, ms.Errorf(ms.ErrorClassObjectExists, "user: %s exists in database: %s but is not managed by the database user controller", user.Spec.Name, databaseName))
+		}
+		sid, err := msSQL.CreateUser(ctx, databaseName, user.Spec.Name, params)
+		if err != nil {
+			return ctrl.Result{}, r.failUser(ctx, user, err)
+		}
+		user.Status.SID = ms.SafeString(sid)
+		user.Status.DatabaseID = db.Status.DatabaseID
+		current = &ms.DatabaseUser{Name: user.Spec.Name, SID: user.Status.SID}
+		status = sqlmi.DatabaseUserConditionCreated
+		condition = user.CreatedCondition()
+	} else {
+		if current == nil || current.SID != user.Status.SID {
+			return ctrl.Result{}, r.failUser(ctx, user, ms.Errorf(ms.ErrorClassObjectMissing, "user: %s with sid: %s no longer exists in database: %s", user.Spec.Name, user.Status.SID, databaseName))
+		}
+		alter := &ms.UserParams{}
+		if user.Spec.LoginName != "" && current.LoginName != user.Spec.LoginName {
+			alter.LoginName = params.LoginName
+		}
+		desiredSchema := user.Spec.DefaultSchema
+		if desiredSchema == "" {
+			desiredSchema = "dbo"
+		}
+		if current.DefaultSchema != desiredSchema {
+			alter.DefaultSchema = &desiredSchema
+		}
+		if params.Password != nil && user.Status.PasswordSecretVersion != secretVersion {
+			logger.Info("password secret changed, resetting user password", "secret-name", user.Spec.PasswordSecret.Name)
+			alter.Password = params.Password
+		}
+		if err = msSQL.AlterUser(ctx, databaseName, user.Spec.Name, alter); err != nil {
+			return ctrl.Result{}, r.failUser(ctx, user, err)
+		}
+	}
+	user.Status.PasswordSecretVersion = secretVersion
+
+	add, drop := diffRoles(user.Spec.Roles, user.Status.Roles, current.Roles)
+	if err = msSQL.UpdateRoleMembership(ctx, databaseName, user.Spec.Name, add, drop); err != nil {
+		return ctrl.Result{}, r.failUser(ctx, user, err)
+	}
+	user.Status.Roles = user.Spec.Roles
+
+	return ctrl.Result{}, r.updateUserStatus(ctx, user, status, condition)
+}
+
+// diffRoles returns the desired roles missing on the server and the roles the controller
+// granted before that are no longer desired, memberships granted by others are left alone
+func diffRoles(desired, managed, actual []string) ([]string, []string) {
+	isMember := map[string]bool{}
+	for _, role := range actual {
+		isMember[role] = true
+	}
+	wanted := map[string]bool{}
+	add := []string{}
+	for _, role := range desired {
+		wanted[role] = true
+		if !isMember[role] {
+			add = append(add, role)
+		}
+	}
+	drop := []string{}
+	for _, role := range managed {
+		if !wanted[role] && isMember[role] {
+			drop = append(drop, role)
+		}
+	}
+	return add, drop
+}
+
+// requestsForDatabase maps a Database to the users referencing it
+func (r *DatabaseUserReconciler) requestsForDatabase(obj client.Object) []reconcile.Request {
+	users := &sqlmi.DatabaseUserList{}
+	if err := r.List(context.Background(), users, client.InNamespace(obj.GetNamespace()), client.MatchingFields{databaseRefField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list DatabaseUsers for Database", "database", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, len(users.Items))
+	for i, user := range users.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: user.Name, Namespace: user.Namespace}}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *DatabaseUserReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Pool == nil {
+		r.Pool = ms.NewPool(ms.DefaultPoolOptions())
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.DatabaseUser{}, databaseRefField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.DatabaseUser).Spec.DatabaseRef}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&sqlmi.DatabaseUser{}).
+		Watches(&source.Kind{Type: &sqlmi.Database{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForDatabase)).
+		Complete(r)
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffRoles(t *testing.T) {
+	tests := []struct {
+		name                     string
+		desired, managed, actual []string
+		add, drop                []string
+	}{
+		{
+			name:    "first sync",
+			desired: []string{"db_datareader", "reporting"},
+			add:     []string{"db_datareader", "reporting"},
+			drop:    []string{},
+		},
+		{
+			name:    "in sync",
+			desired: []string{"db_datareader"},
+			managed: []string{"db_datareader"},
+			actual:  []string{"db_datareader"},
+			add:     []string{},
+			drop:    []string{},
+		},
+		{
+			name:    "role dropped from the spec",
+			desired: []string{"db_datareader"},
+			managed: []string{"db_datareader", "db_datawriter"},
+			actual:  []string{"db_datareader", "db_datawriter"},
+			add:     []string{},
+			drop:    []string{"db_datawriter"},
+		},
+		{
+			name:    "membership granted by others is kept",
+			desired: []string{"db_datareader"},
+			managed: []string{"db_datareader"},
+			actual:  []string{"db_datareader", "db_owner"},
+			add:     []string{},
+			drop:    []string{},
+		},
+		{
+			name:    "membership removed outside of the operator",
+			desired: []string{"db_datareader", "db_datawriter"},
+			managed: []string{"db_datareader", "db_datawriter", "reporting"},
+			actual:  []string{"db_datareader"},
+			add:     []string{"db_datawriter"},
+			drop:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			add, drop := diffRoles(tt.desired, tt.managed, tt.actual)
+			if !reflect.DeepEqual(add, tt.add) || !reflect.DeepEqual(drop, tt.drop) {
+				t.Errorf("diffRoles() = %v, %v, want %v, %v", add, drop, tt.add, tt.drop)
+			}
+		})
+	}
+}
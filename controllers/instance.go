@@ -0,0 +1,211 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sqlManagedInstance reads the sql managed instance through the cached client
+func sqlManagedInstance(ctx context.Context, c client.Client, namespace, name string) (*ms.SQLManagedInstance, error) {
+	mi := &ms.SQLManagedInstance{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, mi); err != nil {
+		return nil, err
+	}
+	return mi, nil
+}
+
+// loginRefKey the secret holding the admin login of the instance, in the namespace of the instance unless it names one
+func loginRefKey(mi *ms.SQLManagedInstance) types.NamespacedName {
+	namespace := mi.Spec.LoginRef.Namespace
+	if namespace == "" {
+		namespace = mi.Namespace
+	}
+	return types.NamespacedName{Name: mi.Spec.LoginRef.Name, Namespace: namespace}
+}
+
+// instanceCredentials reads the admin login of the sql managed instance from its `LoginRef` secret along with the
+// secret resource version
+func instanceCredentials(ctx context.Context, c client.Client, mi *ms.SQLManagedInstance) (string, string, string, error) {
+	sec := &corev1.Secret{}
+	err := c.Get(ctx, loginRefKey(mi), sec)
+	if err != nil {
+		return "", "", "", err
+	}
+	return string(sec.Data["username"]), string(sec.Data["password"]), sec.ResourceVersion, nil
+}
+
+// credentialsError is returned when the login a Database is provisioned with cannot be read
+type credentialsError struct {
+	message string
+}
+
+func (e *credentialsError) Error() string {
+	return e.message
+}
+
+// databaseCredentials reads the login named by the credentials of the Database along with the secret resource
+// version, the admin login of the instance is only used in its place when allowAdmin is set
+func databaseCredentials(ctx context.Context, c client.Client, db *sqlmi.Database, mi *ms.SQLManagedInstance, allowAdmin bool) (string, string, string, error) {
+	ref := db.Spec.Credentials
+	if ref == nil || ref.Name == "" {
+		if !allowAdmin {
+			return "", "", "", &credentialsError{"the database names no credentials secret and the operator does not allow the admin login of the instance, set credentials or run the operator with --allow-instance-admin-credentials"}
+		}
+		return instanceCredentials(ctx, c, mi)
+	}
+
+	sec := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: db.Namespace}, sec); err != nil {
+		if errors.IsNotFound(err) {
+			return "", "", "", &credentialsError{fmt.Sprintf("credentials secret: %s does not exist", ref.Name)}
+		}
+		return "", "", "", err
+	}
+	usernameKey, passwordKey := ref.UsernameKey, ref.PasswordKey
+	if usernameKey == "" {
+		usernameKey = "username"
+	}
+	if passwordKey == "" {
+		passwordKey = "password"
+	}
+	for _, key := range []string{usernameKey, passwordKey} {
+		if len(sec.Data[key]) == 0 {
+			return "", "", "", &credentialsError{fmt.Sprintf("credentials secret: %s does not contain the key: %s", ref.Name, key)}
+		}
+	}
+	return string(sec.Data[usernameKey]), string(sec.Data[passwordKey]), sec.ResourceVersion, nil
+}
+
+// newProvider connects through the pool to the endpoint resolved for the instance, server and port override the
+// endpoint the instance reports
+func newProvider(pool *ms.Pool, mi *ms.SQLManagedInstance, server string, port int, username, password string) (*ms.MSSql, error) {
+	endpoint, err := ms.ResolveEndpoint(mi, server, port)
+	if err != nil {
+		return nil, err
+	}
+	return pool.Provider(endpoint.Server, username, password, endpoint.Port), nil
+}
+
+// instanceProvider connects with the admin login of the instance
+func instanceProvider(ctx context.Context, c client.Client, pool *ms.Pool, mi *ms.SQLManagedInstance, server string, port int) (*ms.MSSql, error) {
+	username, password, _, err := instanceCredentials(ctx, c, mi)
+	if err != nil {
+		return nil, err
+	}
+	return newProvider(pool, mi, server, port, username, password)
+}
+
+// databaseProvider connects with the login the Database is provisioned with, and returns the resource version of
+// the secret it was read from
+func databaseProvider(ctx context.Context, c client.Client, pool *ms.Pool, db *sqlmi.Database, mi *ms.SQLManagedInstance, allowAdmin bool) (*ms.MSSql, string, error) {
+	username, password, version, err := databaseCredentials(ctx, c, db, mi, allowAdmin)
+	if err != nil {
+		return nil, "", err
+	}
+	msSQL, err := newProvider(pool, mi, db.Spec.Server, db.Spec.Port, username, password)
+	return msSQL, version, err
+}
+
+// secretPassword reads the password referenced by ref along with the secret resource version
+func secretPassword(ctx context.Context, c client.Client, namespace string, ref sqlmi.PasswordSecret) (string, string, error) {
+	sec := &corev1.Secret{}
+	if err := c.Get(ctx, types.NamespacedName{Name: ref.Name, Namespace: namespace}, sec); err != nil {
+		return "", "", err
+	}
+	key := ref.PasswordKey
+	if key == "" {
+		key = "password"
+	}
+	password, ok := sec.Data[key]
+	if !ok {
+		return "", "", fmt.Errorf("secret: %s does not contain the key: %s", ref.Name, key)
+	}
+	return string(password), sec.ResourceVersion, nil
+}
+
+// sqlCondition gives condition the class of err as its reason and names the sql server error number in its message,
+// so a slow instance, a refused login and a spec the instance rejects can be told apart
+func sqlCondition(condition *metav1.Condition, err error) *metav1.Condition {
+	if classified := ms.ClassifyError(err); classified != nil && classified.Class != ms.ErrorClassUnknown {
+		condition.Reason = string(classified.Class)
+	}
+	condition.Message = ms.ErrorMessage(err)
+	return condition
+}
+
+// retryable hands err back to the work queue, which retries it with backoff, unless retrying fails the same way
+// until the object or its secrets change; those errors are parked on the condition and the change brings the object
+// back. An instance that is not `Ready` is waited for the same way, its watch brings the object back once it is
+func retryable(err error) error {
+	if _, ok := err.(*instanceNotReadyError); ok {
+		return nil
+	}
+	if classified := ms.ClassifyError(err); classified != nil && classified.Permanent() {
+		return nil
+	}
+	return err
+}
+
+// instanceNotReadyError is returned while the sql managed instance is not in a `Ready` state
+type instanceNotReadyError struct {
+	state string
+}
+
+func (e *instanceNotReadyError) Error() string {
+	return fmt.Sprintf("the sql managed instance is not in a `Ready` state, current state is: %s", e.state)
+}
+
+// instanceReady fails with an instanceNotReadyError unless the sql managed instance is `Ready`
+func instanceReady(mi *ms.SQLManagedInstance) error {
+	if mi.Status.State != "Ready" {
+		return &instanceNotReadyError{state: mi.Status.State}
+	}
+	return nil
+}
+
+// errDatabasePending is returned while the referenced Database has not been created on the instance yet
+var errDatabasePending = fmt.Errorf("the referenced database has not been created yet")
+
+// connectToDatabase resolves a Database object to a provider for its instance and the current name
+// of the database, found through the id recorded in the Database status so renames are detected
+func connectToDatabase(ctx context.Context, c client.Client, pool *ms.Pool, namespace, name string, allowAdmin bool) (*sqlmi.Database, *ms.MSSql, string, error) {
+	db := &sqlmi.Database{}
+	if err := c.Get(ctx, types.NamespacedName{Name: name, Namespace: namespace}, db); err != nil {
+		return nil, nil, "", err
+	}
+	if db.Status.DatabaseID == "" {
+		return db, nil, "", errDatabasePending
+	}
+
+	mi, err := sqlManagedInstance(ctx, c, db.Namespace, db.Spec.SQLManagedInstance)
+	if err != nil {
+		return db, nil, "", err
+	}
+	if err = instanceReady(mi); err != nil {
+		return db, nil, "", err
+	}
+	msSQL, _, err := databaseProvider(ctx, c, pool, db, mi, allowAdmin)
+	if err != nil {
+		return db, nil, "", err
+	}
+
+	dn, err := msSQL.FindDatabaseName(ctx, db.Status.DatabaseID)
+	if err != nil {
+		return db, msSQL, "", err
+	}
+	if dn == nil {
+		return db, msSQL, "", fmt.Errorf("database id: %s does not exist", db.Status.DatabaseID)
+	}
+	if *dn != db.Spec.Name {
+		return db, msSQL, *dn, fmt.Errorf("database name: %s does not match the expected name %s", *dn, db.Spec.Name)
+	}
+	return db, msSQL, *dn, nil
+}
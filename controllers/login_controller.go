@@ -0,0 +1,270 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LoginReconciler reconciles a Login object
+type LoginReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger logr.Logger
+	// Pool shares the connections to the instances between reconciles
+	Pool *ms.Pool
+}
+
+func (r *LoginReconciler) updateLoginStatus(ctx context.Context, login *sqlmi.Login, status string, condition *metav1.Condition) error {
+	login.Status.Status = status
+	if condition != nil {
+		meta.SetStatusCondition(&login.Status.Conditions, *condition)
+	}
+	if status != sqlmi.LoginConditionError {
+		meta.RemoveStatusCondition(&login.Status.Conditions, sqlmi.LoginConditionError)
+	}
+	return r.Status().Update(ctx, login)
+}
+
+// failLogin records the error on the login status and hands it back to the caller when retrying can help
+func (r *LoginReconciler) failLogin(ctx context.Context, login *sqlmi.Login, err error) error {
+	if uerr := r.updateLoginStatus(ctx, login, sqlmi.LoginConditionError, sqlCondition(login.ErroredCondition(err.Error()), err)); uerr != nil {
+		r.Logger.Error(uerr, "failed to update Login status")
+	}
+	return retryable(err)
+}
+
+func (r *LoginReconciler) finalizeLogin(ctx context.Context, login *sqlmi.Login) error {
+	if login.Status.SID == "" {
+		// the login was never created by the controller, nothing to drop
+		return nil
+	}
+	mi, err := sqlManagedInstance(ctx, r.Client, login.Namespace, login.Spec.SQLManagedInstance)
+	if err != nil {
+		// the instance, and the login along with it, is gone
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if !mi.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	if err = instanceReady(mi); err != nil {
+		// the instance watch brings us back once the instance is ready or gone
+		return err
+	}
+	msSQL, err := instanceProvider(ctx, r.Client, r.Pool, mi, login.Spec.Server, login.Spec.Port)
+	if err != nil {
+		return err
+	}
+	return msSQL.DeleteLogin(ctx, login.Spec.Name)
+}
+
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=logins,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=logins/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=logins/finalizers,verbs=update
+
+// Reconcile creates, alters and drops the server login described by a Login object
+func (r *LoginReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	logger := r.Logger.WithValues("login", req.NamespacedName)
+	logger.Info("reconciling login")
+
+	login := &sqlmi.Login{}
+	err := r.Get(ctx, req.NamespacedName, login)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Login resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get Login")
+		return ctrl.Result{}, err
+	}
+
+	/*******************************************************************************************************************
+	* Finalizer to check what to do if we're deleting the resource
+	*******************************************************************************************************************/
+	if login.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(login, databaseFinalizer) {
+			controllerutil.AddFinalizer(login, databaseFinalizer)
+			if err = r.Update(ctx, login); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(login, databaseFinalizer) {
+			if err = r.finalizeLogin(ctx, login); err != nil {
+				if _, ok := err.(*instanceNotReadyError); ok {
+					logger.Info("waiting for the sql managed instance to drop the login", "reason", err.Error())
+					return ctrl.Result{}, nil
+				}
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(login, databaseFinalizer)
+		if err := r.Update(ctx, login); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	/******************************************************************************************************************/
+
+	/*******************************************************************************************************************
+	* Quering the defined secret for the instance connection
+	*******************************************************************************************************************/
+	mi, err := sqlManagedInstance(ctx, r.Client, login.Namespace, login.Spec.SQLManagedInstance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = instanceReady(mi); err != nil {
+		// the instance watch brings us back once the instance is ready
+		return ctrl.Result{}, r.failLogin(ctx, login, err)
+	}
+	msSQL, err := instanceProvider(ctx, r.Client, r.Pool, mi, login.Spec.Server, login.Spec.Port)
+	if err != nil {
+		logger.Error(err, "failed to connect to the sql managed instance", "secret-name", mi.Spec.LoginRef.Name)
+		return ctrl.Result{}, err
+	}
+	/******************************************************************************************************************/
+
+	loginPassword, secretVersion, err := secretPassword(ctx, r.Client, login.Namespace, login.Spec.PasswordSecret)
+	if err != nil {
+		return ctrl.Result{}, r.failLogin(ctx, login, err)
+	}
+
+	if login.Status.SID == "" {
+		sid, err := msSQL.FindLoginSID(ctx, login.Spec.Name)
+		if err != nil {
+			return ctrl.Result{}, r.failLogin(ctx, login, err)
+		}
+		if sid != nil {
+			return ctrl.Result{}, r.failLogin(ctx, login, ms.Errorf(ms.ErrorClassObjectExists, "login: %s exists on server but is not managed by the login controller", login.Spec.Name))
+		}
+		sid, err = msSQL.CreateLogin(ctx, login.Spec.Name, &ms.LoginParams{
+			Password:        &loginPassword,
+			DefaultDatabase: ms.SetString(login.Spec.DefaultDatabase),
+			CheckPolicy:     login.Spec.CheckPolicy,
+			CheckExpiration: login.Spec.CheckExpiration,
+			Disabled:        &login.Spec.Disabled,
+		})
+		if err != nil {
+			return ctrl.Result{}, r.failLogin(ctx, login, err)
+		}
+		login.Status.SID = ms.SafeString(sid)
+		login.Status.PasswordSecretVersion = secretVersion
+		return ctrl.Result{}, r.updateLoginStatus(ctx, login, sqlmi.LoginConditionCreated, login.CreatedCondition())
+	}
+
+	syncResponse, err := msSQL.LoginSyncNeeded(ctx, &ms.LoginConfig{
+		LoginName:       login.Spec.Name,
+		SID:             login.Status.SID,
+		DefaultDatabase: login.Spec.DefaultDatabase,
+		CheckPolicy:     login.Spec.CheckPolicy,
+		CheckExpiration: login.Spec.CheckExpiration,
+		Disabled:        login.Spec.Disabled,
+	})
+	if err != nil {
+		return ctrl.Result{}, r.failLogin(ctx, login, err)
+	}
+	if login.Status.PasswordSecretVersion != secretVersion {
+		logger.Info("password secret changed, resetting login password", "secret-name", login.Spec.PasswordSecret.Name)
+		if syncResponse == nil {
+			syncResponse = &ms.LoginParams{}
+		}
+		syncResponse.Password = &loginPassword
+	}
+	if syncResponse != nil {
+		if err = msSQL.AlterLogin(ctx, login.Spec.Name, syncResponse); err != nil {
+			return ctrl.Result{}, r.failLogin(ctx, login, err)
+		}
+	}
+
+	login.Status.PasswordSecretVersion = secretVersion
+	return ctrl.Result{}, r.updateLoginStatus(ctx, login, sqlmi.LoginConditionSynced, login.SyncedCondition())
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *LoginReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Pool == nil {
+		r.Pool = ms.NewPool(ms.DefaultPoolOptions())
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Login{}, sqlManagedInstanceField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.Login).Spec.SQLManagedInstance}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Login{}, passwordSecretField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.Login).Spec.PasswordSecret.Name}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&sqlmi.Login{}).
+		Watches(&source.Kind{Type: &ms.SQLManagedInstance{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForInstance)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForSecret)).
+		Complete(r)
+}
+
+// requestsForSecret maps a secret to the logins whose password it holds, so a rotated password is set right away
+func (r *LoginReconciler) requestsForSecret(obj client.Object) []reconcile.Request {
+	logins := &sqlmi.LoginList{}
+	if err := r.List(context.Background(), logins, client.InNamespace(obj.GetNamespace()), client.MatchingFields{passwordSecretField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list Logins for Secret", "secret", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, len(logins.Items))
+	for i, login := range logins.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: login.Name, Namespace: login.Namespace}}
+	}
+	return requests
+}
+
+// requestsForInstance maps a sql managed instance to the logins on it, bringing back the ones waiting for it to be ready
+func (r *LoginReconciler) requestsForInstance(obj client.Object) []reconcile.Request {
+	logins := &sqlmi.LoginList{}
+	if err := r.List(context.Background(), logins, client.InNamespace(obj.GetNamespace()), client.MatchingFields{sqlManagedInstanceField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list Logins for SQLManagedInstance", "sql-managed-instance", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, len(logins.Items))
+	for i, login := range logins.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: login.Name, Namespace: login.Namespace}}
+	}
+	return requests
+}
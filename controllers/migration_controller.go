@@ -0,0 +1,271 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const defaultHistoryTable = "__migration_history"
+
+const (
+	// scriptConfigMapField indexes Migrations by the ConfigMaps holding their scripts
+	scriptConfigMapField = ".spec.scripts.configMapKeyRef.name"
+	// scriptSecretField indexes Migrations by the Secrets holding their scripts
+	scriptSecretField = ".spec.scripts.secretKeyRef.name"
+)
+
+// MigrationReconciler reconciles a Migration object
+type MigrationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger logr.Logger
+	// AllowInstanceAdminCredentials applies scripts to databases without credentials with the admin login of the instance
+	AllowInstanceAdminCredentials bool
+	// Pool shares the connections to the instances between reconciles
+	Pool *ms.Pool
+}
+
+func (r *MigrationReconciler) updateMigrationStatus(ctx context.Context, migration *sqlmi.Migration, status string, condition *metav1.Condition) error {
+	migration.Status.Status = status
+	if condition != nil {
+		meta.SetStatusCondition(&migration.Status.Conditions, *condition)
+	}
+	if status != sqlmi.MigrationConditionError {
+		meta.RemoveStatusCondition(&migration.Status.Conditions, sqlmi.MigrationConditionError)
+	}
+	return r.Status().Update(ctx, migration)
+}
+
+// failMigration records the error on the migration status and hands it back to the caller when retrying can help
+func (r *MigrationReconciler) failMigration(ctx context.Context, migration *sqlmi.Migration, err error) error {
+	if uerr := r.updateMigrationStatus(ctx, migration, sqlmi.MigrationConditionError, sqlCondition(migration.ErroredCondition(err.Error()), err)); uerr != nil {
+		r.Logger.Error(uerr, "failed to update Migration status")
+	}
+	return retryable(err)
+}
+
+// migrationScripts reads the body of every declared script
+func (r *MigrationReconciler) migrationScripts(ctx context.Context, migration *sqlmi.Migration) ([]ms.MigrationScript, error) {
+	scripts := []ms.MigrationScript{}
+	for _, script := range migration.Spec.Scripts {
+		var body string
+		switch {
+		case script.ConfigMapKeyRef != nil && script.SecretKeyRef == nil:
+			cm := &corev1.ConfigMap{}
+			if err := r.Get(ctx, types.NamespacedName{Name: script.ConfigMapKeyRef.Name, Namespace: migration.Namespace}, cm); err != nil {
+				return nil, err
+			}
+			value, ok := cm.Data[script.ConfigMapKeyRef.Key]
+			if !ok {
+				return nil, fmt.Errorf("configmap: %s does not contain the key: %s", script.ConfigMapKeyRef.Name, script.ConfigMapKeyRef.Key)
+			}
+			body = value
+		case script.SecretKeyRef != nil && script.ConfigMapKeyRef == nil:
+			sec := &corev1.Secret{}
+			if err := r.Get(ctx, types.NamespacedName{Name: script.SecretKeyRef.Name, Namespace: migration.Namespace}, sec); err != nil {
+				return nil, err
+			}
+			value, ok := sec.Data[script.SecretKeyRef.Key]
+			if !ok {
+				return nil, fmt.Errorf("secret: %s does not contain the key: %s", script.SecretKeyRef.Name, script.SecretKeyRef.Key)
+			}
+			body = string(value)
+		default:
+			return nil, fmt.Errorf("exactly one of configMapKeyRef or secretKeyRef must be set for migration version: %s", script.Version)
+		}
+		scripts = append(scripts, ms.MigrationScript{Version: script.Version, Body: body})
+	}
+	return scripts, nil
+}
+
+// recordApplied sets the declared versions found in the history on the status
+func recordApplied(migration *sqlmi.Migration, applied []ms.AppliedMigration) {
+	history := map[string]bool{}
+	for _, a := range applied {
+		history[a.Version] = true
+	}
+	migration.Status.AppliedVersions = []string{}
+	migration.Status.LastAppliedVersion = ""
+	for _, script := range migration.Spec.Scripts {
+		if history[script.Version] {
+			migration.Status.AppliedVersions = append(migration.Status.AppliedVersions, script.Version)
+			migration.Status.LastAppliedVersion = script.Version
+		}
+	}
+}
+
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=migrations,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=migrations/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=migrations/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+
+// Reconcile applies the declared scripts missing from the history table of the database
+func (r *MigrationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	logger := r.Logger.WithValues("migration", req.NamespacedName)
+	logger.Info("reconciling migration")
+
+	migration := &sqlmi.Migration{}
+	err := r.Get(ctx, req.NamespacedName, migration)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Migration resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get Migration")
+		return ctrl.Result{}, err
+	}
+	if !migration.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	db, msSQL, databaseName, err := connectToDatabase(ctx, r.Client, r.Pool, migration.Namespace, migration.Spec.DatabaseRef, r.AllowInstanceAdminCredentials)
+	if err != nil {
+		if errors.IsNotFound(err) || err == errDatabasePending {
+			// the Database watch brings us back once the database exists
+			logger.Info("waiting for the referenced database", "database", migration.Spec.DatabaseRef)
+			return ctrl.Result{}, r.updateMigrationStatus(ctx, migration, sqlmi.MigrationConditionPending, migration.PendingCondition())
+		}
+		return ctrl.Result{}, r.failMigration(ctx, migration, err)
+	}
+
+	scripts, err := r.migrationScripts(ctx, migration)
+	if err != nil {
+		return ctrl.Result{}, r.failMigration(ctx, migration, err)
+	}
+	table := migration.Spec.HistoryTable
+	if table == "" {
+		table = defaultHistoryTable
+	}
+
+	applied, err := msSQL.AppliedMigrations(ctx, databaseName, table)
+	if err != nil {
+		return ctrl.Result{}, r.failMigration(ctx, migration, err)
+	}
+	migration.Status.DatabaseID = db.Status.DatabaseID
+	recordApplied(migration, applied)
+
+	pending, err := ms.PendingMigrations(scripts, applied)
+	if err != nil {
+		// retrying does not help, the scripts or the history need to be fixed first
+		logger.Error(err, "declared scripts do not match the migration history", "database", databaseName)
+		return ctrl.Result{}, r.updateMigrationStatus(ctx, migration, sqlmi.MigrationConditionError, migration.HistoryMismatchCondition(err.Error()))
+	}
+
+	for i := range pending {
+		if err = msSQL.ApplyMigration(ctx, databaseName, table, &pending[i]); err != nil {
+			return ctrl.Result{}, r.failMigration(ctx, migration, err)
+		}
+		applied = append(applied, ms.AppliedMigration{Version: pending[i].Version, Checksum: pending[i].Checksum()})
+		recordApplied(migration, applied)
+	}
+
+	return ctrl.Result{}, r.updateMigrationStatus(ctx, migration, sqlmi.MigrationConditionApplied, migration.AppliedCondition())
+}
+
+// requestsForDatabase maps a Database to the migrations referencing it
+func (r *MigrationReconciler) requestsForDatabase(obj client.Object) []reconcile.Request {
+	migrations := &sqlmi.MigrationList{}
+	if err := r.List(context.Background(), migrations, client.InNamespace(obj.GetNamespace()), client.MatchingFields{databaseRefField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list Migrations for Database", "database", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, len(migrations.Items))
+	for i, migration := range migrations.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: migration.Name, Namespace: migration.Namespace}}
+	}
+	return requests
+}
+
+// requestsForScripts maps a ConfigMap or Secret to the Migrations reading scripts from it, so an edited script is
+// checked against its recorded checksum without waiting for another change of the Migration
+func (r *MigrationReconciler) requestsForScripts(field string) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		migrations := &sqlmi.MigrationList{}
+		if err := r.List(context.Background(), migrations, client.InNamespace(obj.GetNamespace()), client.MatchingFields{field: obj.GetName()}); err != nil {
+			r.Logger.Error(err, "failed to list Migrations for script source", "name", obj.GetName())
+			return nil
+		}
+		requests := make([]reconcile.Request, len(migrations.Items))
+		for i, migration := range migrations.Items {
+			requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: migration.Name, Namespace: migration.Namespace}}
+		}
+		return requests
+	}
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *MigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Pool == nil {
+		r.Pool = ms.NewPool(ms.DefaultPoolOptions())
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Migration{}, databaseRefField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.Migration).Spec.DatabaseRef}
+	}); err != nil {
+		return err
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Migration{}, scriptConfigMapField, func(rawObj client.Object) []string {
+		names := []string{}
+		for _, script := range rawObj.(*sqlmi.Migration).Spec.Scripts {
+			if script.ConfigMapKeyRef != nil {
+				names = append(names, script.ConfigMapKeyRef.Name)
+			}
+		}
+		return names
+	}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Migration{}, scriptSecretField, func(rawObj client.Object) []string {
+		names := []string{}
+		for _, script := range rawObj.(*sqlmi.Migration).Spec.Scripts {
+			if script.SecretKeyRef != nil {
+				names = append(names, script.SecretKeyRef.Name)
+			}
+		}
+		return names
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&sqlmi.Migration{}).
+		Watches(&source.Kind{Type: &sqlmi.Database{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForDatabase)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForScripts(scriptConfigMapField))).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForScripts(scriptSecretField))).
+		Complete(r)
+}
@@ -0,0 +1,433 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	"github.com/go-logr/logr"
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
+)
+
+// restorePollInterval how often the progress of a running restore is checked
+const restorePollInterval = 10 * time.Second
+
+// RestoreReconciler reconciles a Restore object
+type RestoreReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Logger logr.Logger
+	// AllowInstanceAdminCredentials restores with the admin login of the instance when neither the Restore nor the
+	// Database it hands the restored database to names credentials
+	AllowInstanceAdminCredentials bool
+
+	mu sync.Mutex
+	// restores running in this process keyed by the uid of their Restore
+	restores map[types.UID]*ms.RestoreOperation
+	// Pool shares the connections to the instances between reconciles
+	Pool *ms.Pool
+}
+
+func (r *RestoreReconciler) operation(uid types.UID) *ms.RestoreOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.restores[uid]
+}
+
+func (r *RestoreReconciler) track(uid types.UID, op *ms.RestoreOperation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.restores == nil {
+		r.restores = map[types.UID]*ms.RestoreOperation{}
+	}
+	r.restores[uid] = op
+}
+
+func (r *RestoreReconciler) forget(uid types.UID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.restores, uid)
+}
+
+func (r *RestoreReconciler) updateRestoreStatus(ctx context.Context, restore *sqlmi.Restore, status string, condition *metav1.Condition) error {
+	restore.Status.Status = status
+	if condition != nil {
+		meta.SetStatusCondition(&restore.Status.Conditions, *condition)
+	}
+	if status != sqlmi.RestoreConditionError {
+		meta.RemoveStatusCondition(&restore.Status.Conditions, sqlmi.RestoreConditionError)
+	}
+	return r.Status().Update(ctx, restore)
+}
+
+// failRestore records the error on the restore condition and hands it back to the caller when retrying can help
+func (r *RestoreReconciler) failRestore(ctx context.Context, restore *sqlmi.Restore, err error) error {
+	meta.SetStatusCondition(&restore.Status.Conditions, *sqlCondition(restore.ErroredCondition(err.Error()), err))
+	if uerr := r.Status().Update(ctx, restore); uerr != nil {
+		r.Logger.Error(uerr, "failed to update Restore status")
+	}
+	return retryable(err)
+}
+
+// abortRestore marks the restore as failed for good
+func (r *RestoreReconciler) abortRestore(ctx context.Context, restore *sqlmi.Restore, condition *metav1.Condition) error {
+	now := metav1.Now()
+	restore.Status.CompletionTime = &now
+	return r.updateRestoreStatus(ctx, restore, sqlmi.RestoreConditionFailed, condition)
+}
+
+// restoreDatabaseRef the name of the Database object the restored database is handed to
+func restoreDatabaseRef(restore *sqlmi.Restore) string {
+	if restore.Spec.DatabaseRef != "" {
+		return restore.Spec.DatabaseRef
+	}
+	return restore.Name
+}
+
+// restoreProvider connects with the credentials named by the restore, or else by the Database the restored database
+// is handed to, the admin login of the instance is only used without either when allowed
+func (r *RestoreReconciler) restoreProvider(ctx context.Context, restore *sqlmi.Restore, mi *ms.SQLManagedInstance) (*ms.MSSql, error) {
+	credentials := restore.Spec.Credentials
+	if credentials == nil {
+		db := &sqlmi.Database{}
+		err := r.Get(ctx, types.NamespacedName{Name: restoreDatabaseRef(restore), Namespace: restore.Namespace}, db)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		credentials = db.Spec.Credentials
+	}
+	target := &sqlmi.Database{
+		ObjectMeta: metav1.ObjectMeta{Namespace: restore.Namespace},
+		Spec:       sqlmi.DatabaseSpec{Server: restore.Spec.Server, Port: restore.Spec.Port, Credentials: credentials},
+	}
+	msSQL, _, err := databaseProvider(ctx, r.Client, r.Pool, target, mi, r.AllowInstanceAdminCredentials)
+	return msSQL, err
+}
+
+// adoptDatabase points the Database object at the restored database, creating the object when it does not exist
+func (r *RestoreReconciler) adoptDatabase(ctx context.Context, restore *sqlmi.Restore, databaseID string) error {
+	name := restoreDatabaseRef(restore)
+	db := &sqlmi.Database{}
+	err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: restore.Namespace}, db)
+	if errors.IsNotFound(err) {
+		db = &sqlmi.Database{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   restore.Namespace,
+				Annotations: map[string]string{databaseIDAnnotation: databaseID},
+			},
+			// the settings of the restored database are written into the spec by the first sync instead of being
+			// altered to match an empty one
+			Spec: sqlmi.DatabaseSpec{
+				Name:               restore.Spec.DatabaseName,
+				Server:             restore.Spec.Server,
+				Port:               restore.Spec.Port,
+				SQLManagedInstance: restore.Spec.SQLManagedInstance,
+				Credentials:        restore.Spec.Credentials,
+				DriftPolicy:        sqlmi.DriftAdopt,
+			},
+		}
+		return r.Create(ctx, db)
+	}
+	if err != nil {
+		return err
+	}
+	if db.Spec.Name != restore.Spec.DatabaseName {
+		return fmt.Errorf("database: %s manages the database: %s, not the restored database: %s", db.Name, db.Spec.Name, restore.Spec.DatabaseName)
+	}
+	return r.Patch(ctx, db, AnnotationPatch{Logger: r.Logger, DatabaseID: databaseID})
+}
+
+// finalizeRestore cancels a restore still running on the instance, the database is left in the restoring state
+func (r *RestoreReconciler) finalizeRestore(ctx context.Context, restore *sqlmi.Restore) error {
+	defer r.forget(restore.UID)
+	if restore.Status.Status != sqlmi.RestoreConditionRunning {
+		return nil
+	}
+	mi, err := sqlManagedInstance(ctx, r.Client, restore.Namespace, restore.Spec.SQLManagedInstance)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	msSQL, err := r.restoreProvider(ctx, restore, mi)
+	if err != nil {
+		return err
+	}
+	return msSQL.CancelRestore(ctx, restore.Status.SessionID)
+}
+
+// recoverRestore follows a restore this process did not start, it kept running on the instance while the operator
+// restarted, through the session running it and the restore history once it is gone.  It returns whether the
+// restore still runs.
+func (r *RestoreReconciler) recoverRestore(ctx context.Context, restore *sqlmi.Restore, msSQL *ms.MSSql) (bool, error) {
+	progress, err := msSQL.RestoreProgress(ctx, restore.Status.SessionID)
+	if err != nil {
+		return false, err
+	}
+	if progress != nil {
+		if int(*progress) != restore.Status.PercentComplete {
+			restore.Status.PercentComplete = int(*progress)
+			if err = r.Status().Update(ctx, restore); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+	since := restore.CreationTimestamp.Time
+	if restore.Status.StartTime != nil {
+		since = restore.Status.StartTime.Time
+	}
+	// the instance clock runs in utc
+	recorded, err := msSQL.RestoresRecorded(ctx, restore.Spec.DatabaseName, since.UTC())
+	if err != nil {
+		return false, err
+	}
+	if want := 1 + len(restore.Spec.Logs); recorded < want {
+		return false, &restoreInterrupted{recorded: recorded, want: want}
+	}
+	return false, nil
+}
+
+// restoreInterrupted a restore whose session ended before every backup was restored
+type restoreInterrupted struct {
+	recorded int
+	want     int
+}
+
+func (e *restoreInterrupted) Error() string {
+	return fmt.Sprintf("the restore was interrupted by a restart of the operator after restoring %d of %d backups", e.recorded, e.want)
+}
+
+func restoreMedia(location sqlmi.BackupLocation) ms.RestoreMedia {
+	return ms.RestoreMedia{Disk: location.Disk, URL: location.URL}
+}
+
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=restores,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=restores/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=sqlmi.arc-sql-mi.microsoft.io,resources=restores/finalizers,verbs=update
+
+// Reconcile starts the restore described by a Restore object, tracks its progress and hands the
+// restored database to a Database object
+func (r *RestoreReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = log.FromContext(ctx)
+	logger := r.Logger.WithValues("restore", req.NamespacedName)
+	logger.Info("reconciling restore")
+
+	restore := &sqlmi.Restore{}
+	err := r.Get(ctx, req.NamespacedName, restore)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("Restore resource not found. Ignoring since object must be deleted")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "failed to get Restore")
+		return ctrl.Result{}, err
+	}
+
+	/*******************************************************************************************************************
+	* Finalizer to cancel a restore that is still running
+	*******************************************************************************************************************/
+	if restore.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(restore, databaseFinalizer) {
+			controllerutil.AddFinalizer(restore, databaseFinalizer)
+			if err = r.Update(ctx, restore); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	} else {
+		if controllerutil.ContainsFinalizer(restore, databaseFinalizer) {
+			if err = r.finalizeRestore(ctx, restore); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		controllerutil.RemoveFinalizer(restore, databaseFinalizer)
+		if err := r.Update(ctx, restore); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+	/******************************************************************************************************************/
+
+	// a restore runs once, a new Restore object is needed to restore again
+	if restore.Status.Status == sqlmi.RestoreConditionCompleted || restore.Status.Status == sqlmi.RestoreConditionFailed {
+		return ctrl.Result{}, nil
+	}
+
+	mi, err := sqlManagedInstance(ctx, r.Client, restore.Namespace, restore.Spec.SQLManagedInstance)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if err = instanceReady(mi); err != nil {
+		// the instance watch brings us back once the instance is ready
+		return ctrl.Result{}, r.failRestore(ctx, restore, err)
+	}
+	msSQL, err := r.restoreProvider(ctx, restore, mi)
+	if err != nil {
+		if _, ok := err.(*credentialsError); ok {
+			logger.Info("credentials unavailable", "reason", err.Error())
+		}
+		return ctrl.Result{}, r.failRestore(ctx, restore, err)
+	}
+
+	op := r.operation(restore.UID)
+	switch {
+	case op == nil && restore.Status.Status == sqlmi.RestoreConditionRunning:
+		running, err := r.recoverRestore(ctx, restore, msSQL)
+		if err != nil {
+			if _, ok := err.(*restoreInterrupted); ok {
+				return ctrl.Result{}, r.abortRestore(ctx, restore, restore.FailedCondition(err.Error()))
+			}
+			logger.Error(err, "failed to query restore progress", "session-id", restore.Status.SessionID)
+			return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+		}
+		if running {
+			return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+		}
+
+	case op == nil:
+		if !restore.Spec.Replace {
+			id, err := msSQL.FindDatabaseID(ctx, restore.Spec.DatabaseName)
+			if err != nil {
+				return ctrl.Result{}, r.failRestore(ctx, restore, err)
+			}
+			if id != nil {
+				return ctrl.Result{}, r.abortRestore(ctx, restore, restore.FailedCondition(fmt.Sprintf("database: %s already exists, set replace to overwrite it", restore.Spec.DatabaseName)))
+			}
+		}
+		params := &ms.RestoreParams{
+			From:    restoreMedia(restore.Spec.Source),
+			Replace: restore.Spec.Replace,
+		}
+		for _, location := range restore.Spec.Logs {
+			params.Logs = append(params.Logs, restoreMedia(location))
+		}
+		for _, move := range restore.Spec.Move {
+			params.Move = append(params.Move, ms.RestoreMove{LogicalName: move.LogicalName, PhysicalName: move.PhysicalName})
+		}
+		if restore.Spec.StopAt != nil {
+			// the instance clock runs in utc
+			stopAt := restore.Spec.StopAt.UTC()
+			params.StopAt = &stopAt
+		}
+		op, err = msSQL.StartRestore(ctx, restore.Spec.DatabaseName, params)
+		if err != nil {
+			return ctrl.Result{}, r.failRestore(ctx, restore, err)
+		}
+		r.track(restore.UID, op)
+
+		now := metav1.Now()
+		restore.Status.StartTime = &now
+		restore.Status.SessionID = op.SessionID
+		restore.Status.PercentComplete = 0
+		if err = r.updateRestoreStatus(ctx, restore, sqlmi.RestoreConditionRunning, restore.RunningCondition()); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+
+	case !op.Done():
+		progress, err := msSQL.RestoreProgress(ctx, op.SessionID)
+		if err != nil {
+			logger.Error(err, "failed to query restore progress", "session-id", op.SessionID)
+			return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+		}
+		if progress != nil && int(*progress) != restore.Status.PercentComplete {
+			restore.Status.PercentComplete = int(*progress)
+			if err = r.Status().Update(ctx, restore); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{RequeueAfter: restorePollInterval}, nil
+	}
+
+	if op != nil {
+		if err := op.Err(); err != nil {
+			r.forget(restore.UID)
+			return ctrl.Result{}, r.abortRestore(ctx, restore, sqlCondition(restore.FailedCondition(err.Error()), err))
+		}
+	}
+
+	id, err := msSQL.FindDatabaseID(ctx, restore.Spec.DatabaseName)
+	if err != nil {
+		return ctrl.Result{}, r.failRestore(ctx, restore, err)
+	}
+	if id == nil {
+		r.forget(restore.UID)
+		return ctrl.Result{}, r.abortRestore(ctx, restore, restore.FailedCondition(fmt.Sprintf("restored database: %s was not found", restore.Spec.DatabaseName)))
+	}
+	if err = r.adoptDatabase(ctx, restore, *id); err != nil {
+		return ctrl.Result{}, r.failRestore(ctx, restore, err)
+	}
+	r.forget(restore.UID)
+
+	now := metav1.Now()
+	restore.Status.CompletionTime = &now
+	restore.Status.PercentComplete = 100
+	restore.Status.DatabaseID = *id
+	logger.Info("restore completed", "database", restore.Spec.DatabaseName, "database-id", *id)
+	return ctrl.Result{}, r.updateRestoreStatus(ctx, restore, sqlmi.RestoreConditionCompleted, restore.CompletedCondition())
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RestoreReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Pool == nil {
+		r.Pool = ms.NewPool(ms.DefaultPoolOptions())
+	}
+
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &sqlmi.Restore{}, sqlManagedInstanceField, func(rawObj client.Object) []string {
+		return []string{rawObj.(*sqlmi.Restore).Spec.SQLManagedInstance}
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&sqlmi.Restore{}).
+		Watches(&source.Kind{Type: &ms.SQLManagedInstance{}}, handler.EnqueueRequestsFromMapFunc(r.requestsForInstance)).
+		Complete(r)
+}
+
+// requestsForInstance maps a sql managed instance to the restores on it, bringing back the ones waiting for it to be ready
+func (r *RestoreReconciler) requestsForInstance(obj client.Object) []reconcile.Request {
+	restores := &sqlmi.RestoreList{}
+	if err := r.List(context.Background(), restores, client.InNamespace(obj.GetNamespace()), client.MatchingFields{sqlManagedInstanceField: obj.GetName()}); err != nil {
+		r.Logger.Error(err, "failed to list Restores for SQLManagedInstance", "sql-managed-instance", obj.GetName())
+		return nil
+	}
+	requests := make([]reconcile.Request, len(restores.Items))
+	for i, restore := range restores.Items {
+		requests[i] = reconcile.Request{NamespacedName: types.NamespacedName{Name: restore.Name, Namespace: restore.Namespace}}
+	}
+	return requests
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	sqlmi "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
+	batch "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// defaultSyncImage the sync image used unless the operator or the Database names another
+const defaultSyncImage = "paulplavetzki/sync:v0.0.13"
+
+// defaultSyncServiceAccount the service account the sync jobs run as, created by the operator in the namespace of
+// each Database
+const defaultSyncServiceAccount = "cron-reader"
+
+// DefaultSyncClusterRole the cluster role of config/rbac/cron_role.yaml with the name prefix of config/default
+const DefaultSyncClusterRole = "arc-sql-mi-cron-reader-role"
+
+// defaultSyncJobTemplate the sync CronJob settings when the operator is not configured otherwise, the pods satisfy
+// the restricted pod security standard
+func defaultSyncJobTemplate() *sqlmi.SyncJobTemplate {
+	nonRoot, privilegeEscalation := true, false
+	successful, failed := int32(3), int32(1)
+	return &sqlmi.SyncJobTemplate{
+		Image:              defaultSyncImage,
+		ServiceAccountName: defaultSyncServiceAccount,
+		SecurityContext: &corev1.SecurityContext{
+			AllowPrivilegeEscalation: &privilegeEscalation,
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		},
+		PodSecurityContext: &corev1.PodSecurityContext{
+			RunAsNonRoot:   &nonRoot,
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+		SuccessfulJobsHistoryLimit: &successful,
+		FailedJobsHistoryLimit:     &failed,
+		ConcurrencyPolicy:          batch.ForbidConcurrent,
+	}
+}
+
+// LoadSyncJobTemplate reads the sync CronJob settings of the operator from a yaml file on top of the defaults, an
+// empty path keeps the defaults
+func LoadSyncJobTemplate(path string) (*sqlmi.SyncJobTemplate, error) {
+	defaults := defaultSyncJobTemplate()
+	if path == "" {
+		return defaults, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	configured := &sqlmi.SyncJobTemplate{}
+	if err = yaml.UnmarshalStrict(b, configured); err != nil {
+		return nil, err
+	}
+	return mergeSyncJobTemplate(defaults, configured), nil
+}
+
+// mergeSyncJobTemplate returns a copy of base with every field set on override replacing it
+func mergeSyncJobTemplate(base, override *sqlmi.SyncJobTemplate) *sqlmi.SyncJobTemplate {
+	merged := base.DeepCopy()
+	if override == nil {
+		return merged
+	}
+	o := override.DeepCopy()
+	if o.Image != "" {
+		merged.Image = o.Image
+	}
+	if o.ImagePullPolicy != "" {
+		merged.ImagePullPolicy = o.ImagePullPolicy
+	}
+	if o.ImagePullSecrets != nil {
+		merged.ImagePullSecrets = o.ImagePullSecrets
+	}
+	if o.ServiceAccountName != "" {
+		merged.ServiceAccountName = o.ServiceAccountName
+	}
+	if o.NodeSelector != nil {
+		merged.NodeSelector = o.NodeSelector
+	}
+	if o.Tolerations != nil {
+		merged.Tolerations = o.Tolerations
+	}
+	if o.Resources != nil {
+		merged.Resources = o.Resources
+	}
+	if o.SecurityContext != nil {
+		merged.SecurityContext = o.SecurityContext
+	}
+	if o.PodSecurityContext != nil {
+		merged.PodSecurityContext = o.PodSecurityContext
+	}
+	if o.SuccessfulJobsHistoryLimit != nil {
+		merged.SuccessfulJobsHistoryLimit = o.SuccessfulJobsHistoryLimit
+	}
+	if o.FailedJobsHistoryLimit != nil {
+		merged.FailedJobsHistoryLimit = o.FailedJobsHistoryLimit
+	}
+	if o.ConcurrencyPolicy != "" {
+		merged.ConcurrencyPolicy = o.ConcurrencyPolicy
+	}
+	if o.Suspend != nil {
+		merged.Suspend = o.Suspend
+	}
+	return merged
+}
+
+// applySyncJobTemplate writes the settings onto the sync CronJob
+func applySyncJobTemplate(job *batch.CronJob, template *sqlmi.SyncJobTemplate) {
+	job.Spec.SuccessfulJobsHistoryLimit = template.SuccessfulJobsHistoryLimit
+	job.Spec.FailedJobsHistoryLimit = template.FailedJobsHistoryLimit
+	job.Spec.ConcurrencyPolicy = template.ConcurrencyPolicy
+	job.Spec.Suspend = template.Suspend
+
+	pod := &job.Spec.JobTemplate.Spec.Template.Spec
+	pod.ServiceAccountName = template.ServiceAccountName
+	pod.ImagePullSecrets = template.ImagePullSecrets
+	pod.NodeSelector = template.NodeSelector
+	pod.Tolerations = template.Tolerations
+	pod.SecurityContext = template.PodSecurityContext
+
+	container := &pod.Containers[0]
+	container.Image = template.Image
+	container.ImagePullPolicy = template.ImagePullPolicy
+	container.SecurityContext = template.SecurityContext
+	if template.Resources != nil {
+		container.Resources = *template.Resources
+	}
+}
+
+// syncAccountLabels mark the service account and role binding the operator creates for the sync jobs, only those
+// are replaced or removed by it
+var syncAccountLabels = map[string]string{"app.kubernetes.io/managed-by": "arc-sql-mi"}
+
+func createdForSync(obj client.Object) bool {
+	return obj.GetLabels()["app.kubernetes.io/managed-by"] == syncAccountLabels["app.kubernetes.io/managed-by"]
+}
+
+// ensureSyncServiceAccount creates the service account of the operator sync job template in the namespace of the
+// Database, bound to the sync cluster role, when missing. A service account named by the Database itself is left to
+// whoever created it, so a Database cannot grant the sync role to any account of its namespace
+func (r *DatabaseReconciler) ensureSyncServiceAccount(ctx context.Context, db *sqlmi.Database) error {
+	name := r.SyncJobTemplate.ServiceAccountName
+	if name == "" || r.SyncClusterRole == "" {
+		return nil
+	}
+	if db.Spec.SyncJobTemplate != nil && db.Spec.SyncJobTemplate.ServiceAccountName != "" &&
+		db.Spec.SyncJobTemplate.ServiceAccountName != name {
+		return nil
+	}
+	key := types.NamespacedName{Name: name, Namespace: db.Namespace}
+	account := &corev1.ServiceAccount{}
+	if err := r.Get(ctx, key, account); errors.IsNotFound(err) {
+		account = &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: db.Namespace, Labels: syncAccountLabels},
+		}
+		if err = r.Create(ctx, account); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	desired := &rbac.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: db.Namespace, Labels: syncAccountLabels},
+		RoleRef:    rbac.RoleRef{APIGroup: rbac.GroupName, Kind: "ClusterRole", Name: r.SyncClusterRole},
+		Subjects:   []rbac.Subject{{Kind: rbac.ServiceAccountKind, Name: name, Namespace: db.Namespace}},
+	}
+	binding := &rbac.RoleBinding{}
+	err := r.Get(ctx, key, binding)
+	if errors.IsNotFound(err) {
+		if err = r.Create(ctx, desired); err != nil && !errors.IsAlreadyExists(err) {
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if binding.RoleRef == desired.RoleRef {
+		return nil
+	}
+	if !createdForSync(binding) {
+		return fmt.Errorf("role binding %s/%s binds the %s %s instead of the sync cluster role %s",
+			binding.Namespace, binding.Name, binding.RoleRef.Kind, binding.RoleRef.Name, r.SyncClusterRole)
+	}
+	// the role of a binding cannot change, one written for an earlier sync cluster role is replaced
+	r.Logger.Info("Replacing the role binding of the sync service account", "RoleBinding.Namespace", binding.Namespace,
+		"RoleBinding.Name", binding.Name, "ClusterRole", r.SyncClusterRole)
+	if err = r.Delete(ctx, binding); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return r.Create(ctx, desired)
+}
+
+// releaseSyncServiceAccount removes the service account and role binding created for the sync jobs of the namespace
+// once no Database left in it runs in the CronJob sync mode
+func (r *DatabaseReconciler) releaseSyncServiceAccount(ctx context.Context, namespace string) error {
+	name := r.SyncJobTemplate.ServiceAccountName
+	if name == "" || r.SyncClusterRole == "" {
+		return nil
+	}
+	dbs := &sqlmi.DatabaseList{}
+	if err := r.List(ctx, dbs, client.InNamespace(namespace)); err != nil {
+		return err
+	}
+	for _, db := range dbs.Items {
+		if db.Spec.SyncMode == sqlmi.SyncModeCronJob && db.DeletionTimestamp.IsZero() {
+			return nil
+		}
+	}
+	key := types.NamespacedName{Name: name, Namespace: namespace}
+	for _, obj := range []client.Object{&rbac.RoleBinding{}, &corev1.ServiceAccount{}} {
+		if err := r.Get(ctx, key, obj); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if !createdForSync(obj) {
+			continue
+		}
+		r.Logger.Info("Deleting the sync service account, no Database of the namespace runs in the CronJob sync mode",
+			"Namespace", namespace, "Name", name)
+		if err := r.Delete(ctx, obj); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * 13 *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) expected an error", spec)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	from := time.Date(2021, 7, 1, 10, 17, 30, 0, time.UTC) // a thursday
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2021, 7, 1, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 7, 1, 10, 30, 0, 0, time.UTC)},
+		{"0 */12 * * *", time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2021, 7, 2, 2, 30, 0, 0, time.UTC)},
+		{"0 1 * * 0", time.Date(2021, 7, 4, 1, 0, 0, 0, time.UTC)},
+		{"0 1 * * 7", time.Date(2021, 7, 4, 1, 0, 0, 0, time.UTC)},
+		{"0 1 * * 1-5", time.Date(2021, 7, 2, 1, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2021, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 1", time.Date(2021, 7, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 *", time.Time{}},
+		{"5,20 10 * * *", time.Date(2021, 7, 1, 10, 20, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, 7, 4, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		s, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q) error = %v", tt.spec, err)
+		}
+		if got := s.Next(from); !got.Equal(tt.want) {
+			t.Errorf("Next(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleLastBefore(t *testing.T) {
+	s, err := ParseSchedule("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2021, 7, 1, 10, 0, 0, 0, time.UTC)
+	if got := s.LastBefore(since, since.Add(59*time.Minute)); !got.IsZero() {
+		t.Errorf("LastBefore() = %v, want the zero time", got)
+	}
+	if got, want := s.LastBefore(since, since.Add(3*time.Hour+5*time.Minute)), since.Add(3*time.Hour); !got.Equal(want) {
+		t.Errorf("LastBefore() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultPort the port sql server listens on inside the cluster
+const DefaultPort = 1433
+
+// Endpoint the address the provider connects to
+type Endpoint struct {
+	Server string
+	Port   int
+}
+
+// ResolveEndpoint decides where the instance is reached.  A server or port given explicitly wins, otherwise the
+// primary endpoint reported by the instance (`host,port`) is used, and without one the in-cluster primary service
+func ResolveEndpoint(mi *SQLManagedInstance, server string, port int) (*Endpoint, error) {
+	endpoint := &Endpoint{Server: server, Port: port}
+	if server != "" && port != 0 {
+		return endpoint, nil
+	}
+
+	resolved := &Endpoint{Server: fmt.Sprintf("%s-p-svc.%s.svc", mi.Name, mi.Namespace), Port: DefaultPort}
+	if mi.Status.PrimaryEndpoint != "" {
+		var err error
+		if resolved, err = parseEndpoint(mi.Status.PrimaryEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	if endpoint.Server == "" {
+		endpoint.Server = resolved.Server
+	}
+	if endpoint.Port == 0 {
+		endpoint.Port = resolved.Port
+	}
+	return endpoint, nil
+}
+
+// parseEndpoint reads the `host,port` form sql server uses for its addresses, the port is optional
+func parseEndpoint(value string) (*Endpoint, error) {
+	value = strings.TrimSpace(value)
+	i := strings.LastIndex(value, ",")
+	if i < 0 {
+		if value == "" {
+			return nil, fmt.Errorf("empty sql managed instance endpoint")
+		}
+		return &Endpoint{Server: value, Port: DefaultPort}, nil
+	}
+	host := strings.TrimSpace(value[:i])
+	port, err := strconv.Atoi(strings.TrimSpace(value[i+1:]))
+	if host == "" || err != nil || port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("invalid sql managed instance endpoint: %s", value)
+	}
+	return &Endpoint{Server: host, Port: port}, nil
+}
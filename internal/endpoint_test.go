@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveEndpoint(t *testing.T) {
+	instance := func(primary string) *SQLManagedInstance {
+		return &SQLManagedInstance{
+			ObjectMeta: metav1.ObjectMeta{Name: "sql1", Namespace: "arc"},
+			Status:     SQLManagedInstanceStatus{PrimaryEndpoint: primary},
+		}
+	}
+	tests := []struct {
+		name    string
+		mi      *SQLManagedInstance
+		server  string
+		port    int
+		want    Endpoint
+		wantErr bool
+	}{
+		{"overrides", instance("20.185.3.18,31433"), "10.0.0.4", 1434, Endpoint{"10.0.0.4", 1434}, false},
+		{"primary endpoint", instance("20.185.3.18,31433"), "", 0, Endpoint{"20.185.3.18", 31433}, false},
+		{"primary endpoint without port", instance("sql1.contoso.com"), "", 0, Endpoint{"sql1.contoso.com", DefaultPort}, false},
+		{"server override keeps endpoint port", instance("20.185.3.18,31433"), "sql1.contoso.com", 0, Endpoint{"sql1.contoso.com", 31433}, false},
+		{"port override keeps endpoint host", instance("20.185.3.18,31433"), "", 1434, Endpoint{"20.185.3.18", 1434}, false},
+		{"in-cluster service", instance(""), "", 0, Endpoint{"sql1-p-svc.arc.svc", DefaultPort}, false},
+		{"malformed endpoint", instance("20.185.3.18,abc"), "", 0, Endpoint{}, true},
+		{"malformed endpoint ignored with overrides", instance(",abc"), "10.0.0.4", 1433, Endpoint{"10.0.0.4", 1433}, false},
+	}
+	for _, tt := range tests {
+		got, err := ResolveEndpoint(tt.mi, tt.server, tt.port)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: ResolveEndpoint() error = %v", tt.name, err)
+		}
+		if *got != tt.want {
+			t.Errorf("%s: ResolveEndpoint() = %v, want %v", tt.name, *got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// MigrationScript a versioned t-sql script
+type MigrationScript struct {
+	Version string
+	Body    string
+}
+
+// Checksum sha256 of the script body
+func (s *MigrationScript) Checksum() string {
+	sum := sha256.Sum256([]byte(s.Body))
+	return hex.EncodeToString(sum[:])
+}
+
+// AppliedMigration a row of the migration history table
+type AppliedMigration struct {
+	Version   string
+	Checksum  string
+	AppliedAt time.Time
+}
+
+// PendingMigrations the scripts still to apply, in order. It fails when an applied script changed or when
+// a script is missing from the history while a later one was applied.
+func PendingMigrations(scripts []MigrationScript, applied []AppliedMigration) ([]MigrationScript, error) {
+	history := map[string]string{}
+	for _, a := range applied {
+		history[a.Version] = a.Checksum
+	}
+
+	seen := map[string]bool{}
+	pending := []MigrationScript{}
+	for _, script := range scripts {
+		if seen[script.Version] {
+			return nil, fmt.Errorf("migration version: %s is declared more than once", script.Version)
+		}
+		seen[script.Version] = true
+
+		checksum, ok := history[script.Version]
+		if !ok {
+			pending = append(pending, script)
+			continue
+		}
+		if len(pending) > 0 {
+			return nil, fmt.Errorf("migration version: %s was not applied but the later version: %s was", pending[0].Version, script.Version)
+		}
+		if checksum != script.Checksum() {
+			return nil, fmt.Errorf("checksum mismatch for applied migration version: %s, applied: %s, declared: %s", script.Version, checksum, script.Checksum())
+		}
+	}
+	return pending, nil
+}
+
+var batchSeparator = regexp.MustCompile(`(?i)^\s*GO(\s+(\d+))?\s*(--.*)?$`)
+
+// SplitBatches splits a script into the batches separated by `GO` lines, as sqlcmd does
+func SplitBatches(body string) []string {
+	batches := []string{}
+	var current strings.Builder
+	flush := func(count int) {
+		batch := strings.TrimSpace(current.String())
+		current.Reset()
+		if batch == "" {
+			return
+		}
+		for i := 0; i < count; i++ {
+			batches = append(batches, batch)
+		}
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := batchSeparator.FindStringSubmatch(line); m != nil {
+			count := 1
+			if m[2] != "" {
+				count, _ = strconv.Atoi(m[2])
+			}
+			flush(count)
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+	}
+	flush(1)
+	return batches
+}
+
+// databaseConn a dedicated session switched to the database, hand it back with releaseConn
+func databaseConn(ctx context.Context, sqlDB *sql.DB, databaseName string) (*sql.Conn, error) {
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = conn.ExecContext(ctx, "USE "+QuoteName(databaseName)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// releaseConn switches the session back to master before it returns to the pool, an idle session left in the
+// database would keep it from being dropped
+func (db *MSSql) releaseConn(conn *sql.Conn) {
+	ctx, cancel := db.withTimeout(context.Background(), db.timeouts().Statement)
+	defer cancel()
+	conn.ExecContext(ctx, "USE [master]")
+	conn.Close()
+}
+
+func ensureHistoryTable(ctx context.Context, conn *sql.Conn, table string) error {
+	qt := "[dbo]." + QuoteName(table)
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("IF OBJECT_ID(@p1, N'U') IS NULL "+
+		"CREATE TABLE %s ([version] nvarchar(128) NOT NULL PRIMARY KEY, [checksum] char(64) NOT NULL, "+
+		"[applied_at] datetime2 NOT NULL DEFAULT SYSUTCDATETIME())", qt), qt)
+	return err
+}
+
+// AppliedMigrations reads the migration history of the database, creating the history table when needed
+func (db *MSSql) AppliedMigrations(ctx context.Context, databaseName, table string) ([]AppliedMigration, error) {
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	conn, err := databaseConn(ctx, sqlDB, databaseName)
+	if err != nil {
+		return nil, err
+	}
+	defer db.releaseConn(conn)
+
+	if err = ensureHistoryTable(ctx, conn, table); err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT [version], [checksum], [applied_at] FROM [dbo].%s ORDER BY [applied_at], [version]", QuoteName(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := []AppliedMigration{}
+	for rows.Next() {
+		a := AppliedMigration{}
+		if err = rows.Scan(&a.Version, &a.Checksum, &a.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// ApplyMigration runs every batch of the script and records it in the history table in a single transaction
+func (db *MSSql) ApplyMigration(ctx context.Context, databaseName, table string, script *MigrationScript) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("applying migration", "database", databaseName, "version", script.Version)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Migration)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	conn, err := databaseConn(ctx, sqlDB, databaseName)
+	if err != nil {
+		return err
+	}
+	defer db.releaseConn(conn)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for i, batch := range SplitBatches(script.Body) {
+		if _, err = tx.ExecContext(ctx, batch); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration version: %s failed in batch %d: %w", script.Version, i+1, err)
+		}
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO [dbo].%s ([version], [checksum]) VALUES (@p1, @p2)", QuoteName(table)),
+		script.Version, script.Checksum()); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitBatches(t *testing.T) {
+	body := "CREATE TABLE t (id int)\nGO\n\nINSERT INTO t VALUES (1)\ngo 2\n-- the last batch has no separator\nCREATE VIEW v AS SELECT id FROM t\n  GO  -- trailing comment\nGOTO_LABEL:\nSELECT 1"
+	want := []string{
+		"CREATE TABLE t (id int)",
+		"INSERT INTO t VALUES (1)",
+		"INSERT INTO t VALUES (1)",
+		"-- the last batch has no separator\nCREATE VIEW v AS SELECT id FROM t",
+		"GOTO_LABEL:\nSELECT 1",
+	}
+	if got := SplitBatches(body); !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitBatches() = %q, want %q", got, want)
+	}
+}
+
+func TestPendingMigrations(t *testing.T) {
+	v1 := MigrationScript{Version: "001", Body: "CREATE TABLE a (id int)"}
+	v2 := MigrationScript{Version: "002", Body: "CREATE TABLE b (id int)"}
+	v3 := MigrationScript{Version: "003", Body: "CREATE TABLE c (id int)"}
+	applied := func(scripts ...MigrationScript) []AppliedMigration {
+		history := []AppliedMigration{}
+		for _, s := range scripts {
+			history = append(history, AppliedMigration{Version: s.Version, Checksum: s.Checksum()})
+		}
+		return history
+	}
+
+	tests := []struct {
+		name    string
+		scripts []MigrationScript
+		applied []AppliedMigration
+		want    []string
+		wantErr string
+	}{
+		{name: "nothing applied", scripts: []MigrationScript{v1, v2}, applied: applied(), want: []string{"001", "002"}},
+		{name: "partly applied", scripts: []MigrationScript{v1, v2, v3}, applied: applied(v1), want: []string{"002", "003"}},
+		{name: "all applied", scripts: []MigrationScript{v1, v2}, applied: applied(v1, v2), want: []string{}},
+		{name: "undeclared history is kept", scripts: []MigrationScript{v2}, applied: applied(v1, v2), want: []string{}},
+		{
+			name:    "changed script",
+			scripts: []MigrationScript{{Version: "001", Body: "CREATE TABLE a (id bigint)"}, v2},
+			applied: applied(v1),
+			wantErr: "checksum mismatch",
+		},
+		{name: "out of order", scripts: []MigrationScript{v1, v2, v3}, applied: applied(v1, v3), wantErr: "was not applied but the later version: 003"},
+		{name: "duplicate version", scripts: []MigrationScript{v1, v1}, applied: applied(), wantErr: "declared more than once"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pending, err := PendingMigrations(tt.scripts, tt.applied)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("PendingMigrations() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PendingMigrations() error = %v", err)
+			}
+			got := []string{}
+			for _, p := range pending {
+				got = append(got, p.Version)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PendingMigrations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyMigration(t *testing.T) {
+	server, done := newStandInServer("migration-server", nil)
+	defer done()
+
+	script := &MigrationScript{Version: "001", Body: "CREATE TABLE a (id int)\nGO\nCREATE INDEX ix_a ON a (id)"}
+	db := NewMSSql("migration-server", "sa", "secret", 1433)
+	if err := db.ApplyMigration(context.Background(), "sales", "__migration_history", script); err != nil {
+		t.Fatalf("ApplyMigration() error = %v", err)
+	}
+	want := []string{
+		"USE [sales]",
+		"CREATE TABLE a (id int)",
+		"CREATE INDEX ix_a ON a (id)",
+		"INSERT INTO [dbo].[__migration_history] ([version], [checksum]) VALUES (@p1, @p2)",
+		// the session goes back to the pool outside of the database
+		"USE [master]",
+	}
+	if got := server.Statements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+}
+
+func TestApplyMigrationFailure(t *testing.T) {
+	_, done := newStandInServer("failing-migration-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		if strings.HasPrefix(query, "CREATE INDEX") {
+			return nil, errors.New("incorrect syntax near 'ON'")
+		}
+		return nil, nil
+	})
+	defer done()
+
+	script := &MigrationScript{Version: "002", Body: "CREATE TABLE b (id int)\nGO\nCREATE INDEX ix_b ON b (id)"}
+	err := NewMSSql("failing-migration-server", "sa", "secret", 1433).ApplyMigration(context.Background(), "sales", "__migration_history", script)
+	if err == nil || !strings.Contains(err.Error(), "batch 2") {
+		t.Fatalf("ApplyMigration() error = %v, want a failure in batch 2", err)
+	}
+}
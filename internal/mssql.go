@@ -5,8 +5,11 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	_ "github.com/denisenkom/go-mssqldb"
 	"github.com/go-logr/logr"
@@ -27,7 +30,8 @@ type MSSql struct {
 	User     string `json:"user"`
 	Password string `json:"password"`
 
-	DB *sql.DB
+	// pool the connections are shared through, safe to use from concurrent calls
+	pool *Pool
 }
 
 type DatabaseParams struct {
@@ -45,14 +49,28 @@ type AlterParams struct {
 	CompatibilityLevel         *int
 }
 
-// NewMSSql contructor pattern
+// NewMSSql contructor pattern, the provider gets a pool of its own, use Pool.Provider to share connections
 func NewMSSql(server, user, password string, port int) *MSSql {
-	return &MSSql{
-		Server:   server,
-		Port:     port,
-		User:     user,
-		Password: password,
+	return NewPool(DefaultPoolOptions()).Provider(server, user, password, port)
+}
+
+// driverName of the database/sql driver used to reach the server
+var driverName = "sqlserver"
+
+// connectionString the url of the server, built so the credentials cannot add connection options
+func (db *MSSql) connectionString() string {
+	u := &url.URL{
+		Scheme: "sqlserver",
+		User:   url.UserPassword(db.User, db.Password),
+		Host:   fmt.Sprintf("%s:%d", db.Server, db.Port),
 	}
+	return u.String()
+}
+
+// connection the connection pool shared for the server and login of the provider, it stays open until the
+// returned func releases it
+func (db *MSSql) connection() (*sql.DB, func(), error) {
+	return db.pool.get(db)
 }
 
 type DatabaseSync struct {
@@ -90,6 +108,43 @@ type SyncResponse struct {
 	AllowReadCommittedSnapshot *bool
 }
 
+// FieldDrift a setting of the database that differs from its spec
+type FieldDrift struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (d FieldDrift) String() string {
+	return fmt.Sprintf("%s expected %s, actual %s", d.Field, d.Expected, d.Actual)
+}
+
+// DatabaseDrift pairs the spec values in params with the values of the settings found different on the instance,
+// as returned by SyncNeeded with the Database sync type
+func DatabaseDrift(params *DatabaseConfig, actual *SyncResponse) []FieldDrift {
+	if actual == nil {
+		return nil
+	}
+	var drift []FieldDrift
+	if actual.CompatibilityLevel != nil {
+		drift = append(drift, FieldDrift{Field: "compatibilityLevel",
+			Expected: strconv.Itoa(params.CompatibilityLevel), Actual: strconv.Itoa(*actual.CompatibilityLevel)})
+	}
+	if actual.AllowSnapshotIsolation != nil {
+		drift = append(drift, FieldDrift{Field: "allowSnapshotIsolation",
+			Expected: strconv.FormatBool(params.AllowSnapshotIsolation), Actual: strconv.FormatBool(*actual.AllowSnapshotIsolation)})
+	}
+	if actual.AllowReadCommittedSnapshot != nil {
+		drift = append(drift, FieldDrift{Field: "allowReadCommittedSnapshot",
+			Expected: strconv.FormatBool(params.AllowReadCommittedSnapshot), Actual: strconv.FormatBool(*actual.AllowReadCommittedSnapshot)})
+	}
+	if actual.Parameterization != nil {
+		drift = append(drift, FieldDrift{Field: "parameterization",
+			Expected: params.Parameterization, Actual: *actual.Parameterization})
+	}
+	return drift
+}
+
 func (db *MSSql) SyncNeeded(ctx context.Context, params *DatabaseConfig, syncType SyncType) (*SyncResponse, error) {
 	_ = log.FromContext(ctx)
 	logger := log.Log
@@ -98,11 +153,6 @@ func (db *MSSql) SyncNeeded(ctx context.Context, params *DatabaseConfig, syncTyp
 
 	syncResponse := &SyncResponse{}
 
-	// Build connection string
-	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", db.Server, db.User, db.Password, db.Port)
-
-	var err error
-
 	if params.DatabaseID != "" {
 		dn, err := db.FindDatabaseName(ctx, params.DatabaseID)
 		if err != nil {
@@ -116,15 +166,14 @@ func (db *MSSql) SyncNeeded(ctx context.Context, params *DatabaseConfig, syncTyp
 		}
 	}
 
-	// Create connection pool
-	db.DB, err = sql.Open("sqlserver", connString)
-	if err != nil {
-		return nil, err
-	}
-	err = db.DB.Ping()
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
 	if err != nil {
 		return nil, err
 	}
+	defer release()
+
 	sqlStmt := "SELECT [name], " +
 		"[state], " +
 		"[is_read_only] as [isReadOnly], " +
@@ -136,21 +185,13 @@ func (db *MSSql) SyncNeeded(ctx context.Context, params *DatabaseConfig, syncTyp
 		"IIF(is_read_committed_snapshot_on = 1, 'true', 'false') as [allowReadCommittedSnapshot], " +
 		"IIF(is_parameterization_forced = 0, 'simple', 'forced' ) as [parameterization] " +
 		"FROM sys.databases " +
-		"WHERE [name] = '%s' " +
+		"WHERE [name] = @p1 " +
 		"FOR JSON PATH, ROOT ('database')"
 
-	stmt, err := db.DB.Prepare(fmt.Sprintf(sqlStmt, params.DatabaseName))
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
-
-	row := stmt.QueryRow()
 	var output string
-	err = row.Scan(&output)
-	// sql: no rows in result set
+	err = sqlDB.QueryRowContext(ctx, sqlStmt, params.DatabaseName).Scan(&output)
 	if err != nil {
-		if strings.Contains(err.Error(), "sql: no rows in result set") {
+		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
@@ -180,7 +221,8 @@ func (db *MSSql) SyncNeeded(ctx context.Context, params *DatabaseConfig, syncTyp
 		}
 		requireSync = true
 	}
-	if params.CompatibilityLevel != sync.Database[0].CompatibilityLevel {
+	// settings left out of the spec keep whatever the instance has
+	if params.CompatibilityLevel != 0 && params.CompatibilityLevel != sync.Database[0].CompatibilityLevel {
 		if syncType == State {
 			syncResponse.CompatibilityLevel = &params.CompatibilityLevel
 		} else {
@@ -188,7 +230,7 @@ func (db *MSSql) SyncNeeded(ctx context.Context, params *DatabaseConfig, syncTyp
 		}
 		requireSync = true
 	}
-	if params.Parameterization != sync.Database[0].Parameterization {
+	if params.Parameterization != "" && params.Parameterization != sync.Database[0].Parameterization {
 		if syncType == State {
 			syncResponse.Parameterization = &params.Parameterization
 		} else {
@@ -210,34 +252,20 @@ func (db *MSSql) FindDatabaseID(ctx context.Context, databaseName string) (*stri
 	logger := log.Log
 
 	logger.Info("finding the database if it exists by Name", "name", databaseName)
-	// Build connection string
-	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", db.Server, db.User, db.Password, db.Port)
-
-	var err error
-
-	// Create connection pool
-	db.DB, err = sql.Open("sqlserver", connString)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
 	if err != nil {
 		return nil, err
 	}
-	err = db.DB.Ping()
-	if err != nil {
-		return nil, err
-	}
-	sqlStmt := "SELECT CAST(recovery_fork_guid AS char(36)) as recovery_fork_guid FROM sys.database_recovery_status drs JOIN sys.databases dbs ON drs.database_id = dbs.database_id WHERE dbs.[name] = '%s'"
+	defer release()
 
-	stmt, err := db.DB.Prepare(fmt.Sprintf(sqlStmt, databaseName))
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
+	sqlStmt := "SELECT CAST(recovery_fork_guid AS char(36)) as recovery_fork_guid FROM sys.database_recovery_status drs JOIN sys.databases dbs ON drs.database_id = dbs.database_id WHERE dbs.[name] = @p1"
 
-	row := stmt.QueryRow()
 	var id string
-	err = row.Scan(&id)
-	// sql: no rows in result set
+	err = sqlDB.QueryRowContext(ctx, sqlStmt, databaseName).Scan(&id)
 	if err != nil {
-		if strings.Contains(err.Error(), "sql: no rows in result set") {
+		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
@@ -251,33 +279,20 @@ func (db *MSSql) FindDatabaseName(ctx context.Context, id string) (*string, erro
 	logger := log.Log
 
 	logger.Info("finding the database if it exists by ID", "id", id)
-	// Build connection string
-	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", db.Server, db.User, db.Password, db.Port)
-
-	var err error
-
-	// Create connection pool
-	db.DB, err = sql.Open("sqlserver", connString)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
 	if err != nil {
 		return nil, err
 	}
-	err = db.DB.Ping()
-	if err != nil {
-		return nil, err
-	}
-	sqlStmt := "select dbs.[name] FROM sys.database_recovery_status drs JOIN sys.databases dbs ON drs.database_id = dbs.database_id where drs.recovery_fork_guid = '%s'"
+	defer release()
 
-	stmt, err := db.DB.Prepare(fmt.Sprintf(sqlStmt, id))
-	if err != nil {
-		return nil, err
-	}
-	defer stmt.Close()
+	sqlStmt := "select dbs.[name] FROM sys.database_recovery_status drs JOIN sys.databases dbs ON drs.database_id = dbs.database_id where drs.recovery_fork_guid = @p1"
 
-	row := stmt.QueryRow()
 	var name string
-	err = row.Scan(&name)
+	err = sqlDB.QueryRowContext(ctx, sqlStmt, id).Scan(&name)
 	if err != nil {
-		if strings.Contains(err.Error(), "sql: no rows in result set") {
+		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
@@ -285,118 +300,191 @@ func (db *MSSql) FindDatabaseName(ctx context.Context, id string) (*string, erro
 	return &name, nil
 }
 
+// DatabaseIsEmpty reports whether the database holds no user objects
+func (db *MSSql) DatabaseIsEmpty(ctx context.Context, databaseName string) (bool, error) {
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	var count int
+	err = sqlDB.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s.sys.objects WHERE [is_ms_shipped] = 0", QuoteName(databaseName))).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count == 0, nil
+}
+
 func (db *MSSql) DeleteDatabase(ctx context.Context, databaseName string) error {
 	_ = log.FromContext(ctx)
 	logger := log.Log
 
 	logger.Info("deleting the database", "name", databaseName)
-	// Build connection string
-	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", db.Server, db.User, db.Password, db.Port)
-
-	var err error
-
-	// Create connection pool
-	db.DB, err = sql.Open("sqlserver", connString)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
 	if err != nil {
 		return err
 	}
-	defer db.DB.Close()
-	err = db.DB.Ping()
+	defer release()
+
+	_, err = sqlDB.ExecContext(ctx, fmt.Sprintf("IF DB_ID(@p1) IS NOT NULL DROP DATABASE %s;", QuoteName(databaseName)), databaseName)
+	return err
+}
+
+// Session a session connected to a database
+type Session struct {
+	SessionID   int
+	LoginName   string
+	HostName    string
+	ProgramName string
+	Status      string
+}
+
+// DatabaseSessions the sessions, other than our own, connected to the database
+func (db *MSSql) DatabaseSessions(ctx context.Context, databaseName string) ([]Session, error) {
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	var dbID int64
-	result, err := db.DB.Query(fmt.Sprintf("SELECT DB_ID(N'%s') AS [ID];", databaseName))
+	defer release()
+
+	rows, err := sqlDB.QueryContext(ctx, "SELECT [session_id], [login_name], ISNULL([host_name], ''), ISNULL([program_name], ''), [status] "+
+		"FROM sys.dm_exec_sessions WHERE [database_id] = DB_ID(@p1) AND [session_id] <> @@SPID ORDER BY [session_id]", databaseName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer result.Close()
-	result.Next()
+	defer rows.Close()
 
-	if err = result.Scan(&dbID); err == nil {
-		rows, err := db.DB.Query(fmt.Sprintf("DROP DATABASE %s;", databaseName))
-		if err != nil {
-			return err
+	sessions := []Session{}
+	for rows.Next() {
+		s := Session{}
+		if err = rows.Scan(&s.SessionID, &s.LoginName, &s.HostName, &s.ProgramName, &s.Status); err != nil {
+			return nil, err
 		}
-		defer rows.Close()
-	} else {
-		logger.Info("database doesn't exist returning nil")
+		sessions = append(sessions, s)
 	}
-
-	return nil
+	return sessions, rows.Err()
 }
 
-func (db *MSSql) CreateDatabase(ctx context.Context, databaseName string, params *DatabaseParams) (*string, error) {
+// RollbackAndDeleteDatabase disconnects every session from the database, rolling back their open
+// transactions, and drops it
+func (db *MSSql) RollbackAndDeleteDatabase(ctx context.Context, databaseName string) error {
 	_ = log.FromContext(ctx)
 	logger := log.Log
 
-	logger.Info("creating the database", "name", databaseName)
-	// Build connection string
-	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", db.Server, db.User, db.Password, db.Port)
+	logger.Info("disconnecting sessions and deleting the database", "name", databaseName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	qdb := QuoteName(databaseName)
+	_, err = sqlDB.ExecContext(ctx, fmt.Sprintf("IF DB_ID(@p1) IS NOT NULL BEGIN "+
+		"ALTER DATABASE %s SET SINGLE_USER WITH ROLLBACK IMMEDIATE; DROP DATABASE %s; END", qdb, qdb), databaseName)
+	return err
+}
+
+// AlterResult the outcome of changing one setting of a database
+type AlterResult struct {
+	// Field the spec field of the setting
+	Field string
+	// Value the value the setting was changed to
+	Value string
+	// Err why the change failed, nil when it was applied
+	Err error
+}
+
+// alterStatement the statement changing one setting of a database
+type alterStatement struct {
+	field string
+	value string
+	sql   string
+}
 
-	var err error
+// CreateDatabase creates the database and sets its options, returning the outcome of every option it set.  When
+// setting an option fails the id of the created database is returned along with the error.
+func (db *MSSql) CreateDatabase(ctx context.Context, databaseName string, params *DatabaseParams) (*string, []AlterResult, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
 
-	// Create connection pool
-	db.DB, err = sql.Open("sqlserver", connString)
+	logger.Info("creating the database", "name", databaseName)
+	// both statements are built first so an invalid option does not leave a half configured database behind
+	create, err := buildDatabaseSQL("CREATE", databaseName, params)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer db.DB.Close()
-	err = db.DB.Ping()
+	alters, err := buildAlterSQL(databaseName, params)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	_, err = db.DB.Exec(buildDatabaseSQL("CREATE", databaseName, params))
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Create)
+	defer cancel()
+	sqlDB, release, err := db.connection()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	defer release()
+
+	if _, err = sqlDB.ExecContext(ctx, create); err != nil {
+		return nil, nil, err
 	}
 	// now we need to alter database with params
-	if err = executeAlterCommands(db.DB, logger, databaseName, params); err != nil {
-		return nil, err
+	results, alterErr := executeAlterCommands(ctx, sqlDB, logger, databaseName, alters)
+	id, err := db.FindDatabaseID(ctx, databaseName)
+	if alterErr != nil {
+		return id, results, alterErr
 	}
-	return db.FindDatabaseID(ctx, databaseName)
+	return id, results, err
 }
 
-func (db *MSSql) AlterDatabase(ctx context.Context, databaseName string, params *DatabaseParams) error {
+// AlterDatabase sets the options of the database, returning the outcome of every option it set
+func (db *MSSql) AlterDatabase(ctx context.Context, databaseName string, params *DatabaseParams) ([]AlterResult, error) {
 	_ = log.FromContext(ctx)
 	logger := log.Log
 
 	logger.Info("altering the database", "name", databaseName)
-	// Build connection string
-	connString := fmt.Sprintf("server=%s;user id=%s;password=%s;port=%d", db.Server, db.User, db.Password, db.Port)
-
-	var err error
-
-	// Create connection pool
-	db.DB, err = sql.Open("sqlserver", connString)
+	alters, err := buildAlterSQL(databaseName, params)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer db.DB.Close()
-	err = db.DB.Ping()
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer release()
 
-	return executeAlterCommands(db.DB, logger, databaseName, params)
+	return executeAlterCommands(ctx, sqlDB, logger, databaseName, alters)
 }
 
-func executeAlterCommands(db *sql.DB, logger logr.Logger, databaseName string, params *DatabaseParams) error {
-	altStatements := buildAlterSQL(databaseName, params)
-	errors := []error{}
-	if len(altStatements) > 0 {
-		for _, alter := range altStatements {
-			_, err := db.Exec(alter)
-			if err != nil {
-				logger.V(0).Info(err.Error())
-				errors = append(errors, err)
-			}
+// executeAlterCommands runs every statement, a failing one does not keep the others from running, and returns the
+// outcome of each along with the first failure
+func executeAlterCommands(ctx context.Context, db *sql.DB, logger logr.Logger, databaseName string, altStatements []alterStatement) ([]AlterResult, error) {
+	results := make([]AlterResult, 0, len(altStatements))
+	var errors []error
+	for _, alter := range altStatements {
+		_, err := db.ExecContext(ctx, alter.sql)
+		if err != nil {
+			logger.Error(err, "failed to alter the database", "name", databaseName, "field", alter.field, "value", alter.value)
+			errors = append(errors, err)
 		}
+		results = append(results, AlterResult{Field: alter.field, Value: alter.value, Err: err})
 	}
 	if len(errors) > 0 {
-		return fmt.Errorf("errors while running alter on database: %s", databaseName)
+		return results, fmt.Errorf("errors while running alter on database: %s, %d of %d settings failed: %w",
+			databaseName, len(errors), len(altStatements), errors[0])
 	}
-	return nil
+	return results, nil
 }
 
 func onOff(value bool) string {
@@ -407,35 +495,1258 @@ func onOff(value bool) string {
 	}
 }
 
-func buildAlterSQL(databaseName string, params *DatabaseParams) []string {
-	altStatements := []string{}
-	altTemplate := fmt.Sprintf("Alter DATABASE %s ", databaseName)
+// parameterizationOptions the PARAMETERIZATION settings a database accepts, keyed by their spec value
+var parameterizationOptions = map[string]string{
+	"simple": "SIMPLE",
+	"forced": "FORCED",
+}
+
+// compatibilityLevels the COMPATIBILITY_LEVEL values a managed instance accepts
+var compatibilityLevels = map[int]bool{100: true, 110: true, 120: true, 130: true, 140: true, 150: true, 160: true}
+
+// collationName collation names are bare words, the server rejects the ones it does not know
+var collationName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func buildAlterSQL(databaseName string, params *DatabaseParams) ([]alterStatement, error) {
+	altStatements := []alterStatement{}
+	altTemplate := fmt.Sprintf("ALTER DATABASE %s", QuoteName(databaseName))
 
 	if params.Parameterization != nil && *params.Parameterization != "" {
-		altStatements = append(altStatements, fmt.Sprintf("%s SET PARAMETERIZATION %s;", altTemplate, *params.Parameterization))
+		option, ok := parameterizationOptions[strings.ToLower(*params.Parameterization)]
+		if !ok {
+			return nil, invalidRequest("parameterization: %s is not one of simple or forced", *params.Parameterization)
+		}
+		altStatements = append(altStatements, alterStatement{field: "parameterization", value: *params.Parameterization,
+			sql: fmt.Sprintf("%s SET PARAMETERIZATION %s;", altTemplate, option)})
 	}
 	// if params.AllowReadCommittedSnapshot != nil {
 	// 	altStatements = append(altStatements, fmt.Sprintf("%s SET READ_COMMITTED_SNAPSHOT %s;", altTemplate, onOff(*params.AllowReadCommittedSnapshot)))
 	// }
 	if params.AllowSnapshotIsolation != nil {
-		altStatements = append(altStatements, fmt.Sprintf("%s SET ALLOW_SNAPSHOT_ISOLATION %s;", altTemplate, onOff(*params.AllowSnapshotIsolation)))
+		altStatements = append(altStatements, alterStatement{field: "allowSnapshotIsolation", value: strconv.FormatBool(*params.AllowSnapshotIsolation),
+			sql: fmt.Sprintf("%s SET ALLOW_SNAPSHOT_ISOLATION %s;", altTemplate, onOff(*params.AllowSnapshotIsolation))})
 	}
-	if params.CompatibilityLevel != nil {
-		altStatements = append(altStatements, fmt.Sprintf("%s SET COMPATIBILITY_LEVEL = %d;", altTemplate, *params.CompatibilityLevel))
+	// a compatibility level left out of the spec keeps the default of the instance
+	if params.CompatibilityLevel != nil && *params.CompatibilityLevel != 0 {
+		if !compatibilityLevels[*params.CompatibilityLevel] {
+			return nil, invalidRequest("compatibility level: %d is not supported", *params.CompatibilityLevel)
+		}
+		altStatements = append(altStatements, alterStatement{field: "compatibilityLevel", value: strconv.Itoa(*params.CompatibilityLevel),
+			sql: fmt.Sprintf("%s SET COMPATIBILITY_LEVEL = %d;", altTemplate, *params.CompatibilityLevel)})
 	}
-	return altStatements
+	return altStatements, nil
 }
 
-func buildDatabaseSQL(verb string, databaseName string, params *DatabaseParams) string {
+func buildDatabaseSQL(verb string, databaseName string, params *DatabaseParams) (string, error) {
 	var b strings.Builder
-	var count int8 = 0
 
-	fmt.Fprintf(&b, "%s DATABASE %s ", verb, databaseName)
+	fmt.Fprintf(&b, "%s DATABASE %s", verb, QuoteName(databaseName))
+
+	if collation := SafeString(params.Collation); collation != "" {
+		if !collationName.MatchString(collation) {
+			return "", invalidRequest("collation: %s is not a valid collation name", collation)
+		}
+		fmt.Fprintf(&b, " COLLATE %s", collation)
+	}
+	b.WriteString(";")
+
+	return b.String(), nil
+}
+
+type LoginParams struct {
+	Password        *string
+	DefaultDatabase *string
+	CheckPolicy     *bool
+	CheckExpiration *bool
+	Disabled        *bool
+}
+
+type LoginConfig struct {
+	LoginName       string
+	SID             string
+	DefaultDatabase string
+	CheckPolicy     *bool
+	CheckExpiration *bool
+	Disabled        bool
+}
+
+// FindLoginSID finds the security identifier of a sql login
+func (db *MSSql) FindLoginSID(ctx context.Context, loginName string) (*string, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("finding the login if it exists by Name", "name", loginName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var sid string
+	err = sqlDB.QueryRowContext(ctx, "SELECT CONVERT(varchar(172), [sid], 1) FROM sys.sql_logins WHERE [name] = @p1", loginName).Scan(&sid)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &sid, nil
+}
+
+// FindLoginName finds the name of a sql login by its security identifier
+func (db *MSSql) FindLoginName(ctx context.Context, sid string) (*string, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("finding the login if it exists by SID", "sid", sid)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var name string
+	err = sqlDB.QueryRowContext(ctx, "SELECT [name] FROM sys.sql_logins WHERE [sid] = CONVERT(varbinary(85), @p1, 1)", sid).Scan(&name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &name, nil
+}
+
+// LoginSyncNeeded compares the login on the server with the desired config and
+// returns the params needed to bring it back in line, nil when nothing differs
+func (db *MSSql) LoginSyncNeeded(ctx context.Context, params *LoginConfig) (*LoginParams, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.V(1).Info("determine login syncing", "login", params.LoginName)
+
+	if params.SID != "" {
+		ln, err := db.FindLoginName(ctx, params.SID)
+		if err != nil {
+			return nil, err
+		}
+		if ln == nil {
+			return nil, fmt.Errorf("login sid: %s does not exist", params.SID)
+		}
+		if SafeString(ln) != params.LoginName {
+			return nil, fmt.Errorf("login name: %s does not match the expected name %s", SafeString(ln), params.LoginName)
+		}
+	}
+
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var defaultDatabase string
+	var disabled, policyChecked, expirationChecked bool
+	err = sqlDB.QueryRowContext(ctx, "SELECT [default_database_name], [is_disabled], [is_policy_checked], [is_expiration_checked] "+
+		"FROM sys.sql_logins WHERE [name] = @p1", params.LoginName).Scan(&defaultDatabase, &disabled, &policyChecked, &expirationChecked)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("login: %s does not exist", params.LoginName)
+		}
+		return nil, err
+	}
+
+	syncResponse := &LoginParams{}
+	requireSync := false
+
+	desiredDatabase := params.DefaultDatabase
+	if desiredDatabase == "" {
+		desiredDatabase = "master"
+	}
+	if desiredDatabase != defaultDatabase {
+		syncResponse.DefaultDatabase = &desiredDatabase
+		requireSync = true
+	}
+	if params.CheckPolicy != nil && *params.CheckPolicy != policyChecked {
+		syncResponse.CheckPolicy = params.CheckPolicy
+		requireSync = true
+	}
+	if params.CheckExpiration != nil && *params.CheckExpiration != expirationChecked {
+		syncResponse.CheckExpiration = params.CheckExpiration
+		requireSync = true
+	}
+	if params.Disabled != disabled {
+		syncResponse.Disabled = &params.Disabled
+		requireSync = true
+	}
+	if requireSync {
+		return syncResponse, nil
+	}
+	return nil, nil
+}
+
+// CreateLogin creates the sql login and returns its security identifier
+func (db *MSSql) CreateLogin(ctx context.Context, loginName string, params *LoginParams) (*string, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("creating the login", "name", loginName)
+	if params.Password == nil {
+		return nil, invalidRequest("a password is required to create login: %s", loginName)
+	}
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	for _, stmt := range buildLoginSQL("CREATE", loginName, params) {
+		if _, err := sqlDB.ExecContext(ctx, stmt); err != nil {
+			return nil, err
+		}
+	}
+	return db.FindLoginSID(ctx, loginName)
+}
+
+// AlterLogin applies the non nil params to an existing sql login
+func (db *MSSql) AlterLogin(ctx context.Context, loginName string, params *LoginParams) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("altering the login", "name", loginName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, stmt := range buildLoginSQL("ALTER", loginName, params) {
+		if _, err := sqlDB.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteLogin drops the sql login if it exists
+func (db *MSSql) DeleteLogin(ctx context.Context, loginName string) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("deleting the login", "name", loginName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = sqlDB.ExecContext(ctx, fmt.Sprintf("IF EXISTS (SELECT 1 FROM sys.sql_logins WHERE [name] = @p1) DROP LOGIN %s;", QuoteName(loginName)), loginName)
+	return err
+}
+
+func buildLoginSQL(verb string, loginName string, params *LoginParams) []string {
+	statements := []string{}
+	options := []string{}
+
+	if params.Password != nil {
+		options = append(options, fmt.Sprintf("PASSWORD = %s", QuoteString(*params.Password)))
+	}
+	if params.DefaultDatabase != nil && *params.DefaultDatabase != "" {
+		options = append(options, fmt.Sprintf("DEFAULT_DATABASE = %s", QuoteName(*params.DefaultDatabase)))
+	}
+	if params.CheckPolicy != nil {
+		options = append(options, fmt.Sprintf("CHECK_POLICY = %s", onOff(*params.CheckPolicy)))
+	}
+	if params.CheckExpiration != nil {
+		options = append(options, fmt.Sprintf("CHECK_EXPIRATION = %s", onOff(*params.CheckExpiration)))
+	}
+	if len(options) > 0 {
+		statements = append(statements, fmt.Sprintf("%s LOGIN %s WITH %s;", verb, QuoteName(loginName), strings.Join(options, ", ")))
+	}
+	if params.Disabled != nil {
+		if *params.Disabled {
+			statements = append(statements, fmt.Sprintf("ALTER LOGIN %s DISABLE;", QuoteName(loginName)))
+		} else if verb == "ALTER" {
+			statements = append(statements, fmt.Sprintf("ALTER LOGIN %s ENABLE;", QuoteName(loginName)))
+		}
+	}
+	return statements
+}
+
+type UserParams struct {
+	LoginName     *string
+	Password      *string
+	DefaultSchema *string
+}
 
-	if params.Collation != nil {
-		fmt.Fprintf(&b, "Collate %s", SafeString(params.Collation))
-		count++
+// DatabaseUser is a user as found inside a database
+type DatabaseUser struct {
+	Name          string
+	SID           string
+	DefaultSchema string
+	LoginName     string
+	Roles         []string
+}
+
+// execInDatabase runs a single statement in the context of the given database
+func execInDatabase(ctx context.Context, conn *sql.DB, databaseName, stmt string) error {
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("EXEC %s.sys.sp_executesql @p1;", QuoteName(databaseName)), stmt)
+	return err
+}
+
+// FindUser finds a user and its role memberships inside the database, nil when it does not exist
+func (db *MSSql) FindUser(ctx context.Context, databaseName, userName string) (*DatabaseUser, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.V(1).Info("finding the user if it exists by Name", "database", databaseName, "name", userName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	qdb := QuoteName(databaseName)
+	user := &DatabaseUser{Name: userName}
+	err = sqlDB.QueryRowContext(ctx, fmt.Sprintf("SELECT CONVERT(varchar(172), dp.[sid], 1), ISNULL(dp.[default_schema_name], ''), ISNULL(sp.[name], '') "+
+		"FROM %s.sys.database_principals dp LEFT JOIN sys.server_principals sp ON dp.[sid] = sp.[sid] "+
+		"WHERE dp.[name] = @p1 AND dp.[type] IN ('S', 'U', 'E', 'X')", qdb), userName).Scan(&user.SID, &user.DefaultSchema, &user.LoginName)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	rows, err := sqlDB.QueryContext(ctx, fmt.Sprintf("SELECT r.[name] FROM %s.sys.database_role_members rm "+
+		"JOIN %s.sys.database_principals r ON rm.[role_principal_id] = r.[principal_id] "+
+		"JOIN %s.sys.database_principals m ON rm.[member_principal_id] = m.[principal_id] "+
+		"WHERE m.[name] = @p1", qdb, qdb, qdb), userName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var role string
+		if err = rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		user.Roles = append(user.Roles, role)
+	}
+	return user, rows.Err()
+}
+
+// CreateUser creates a user for a login, or a contained user when a password is given,
+// and returns its security identifier
+func (db *MSSql) CreateUser(ctx context.Context, databaseName, userName string, params *UserParams) (*string, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("creating the user", "database", databaseName, "name", userName)
+	if (params.LoginName == nil) == (params.Password == nil) {
+		return nil, invalidRequest("user: %s requires either a login or a password", userName)
 	}
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	return b.String()
+	if err := execInDatabase(ctx, sqlDB, databaseName, buildUserSQL("CREATE", userName, params)); err != nil {
+		return nil, err
+	}
+	user, err := db.FindUser(ctx, databaseName, userName)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user: %s was not found in database: %s after creating it", userName, databaseName)
+	}
+	return &user.SID, nil
+}
+
+// AlterUser applies the non nil params to an existing user
+func (db *MSSql) AlterUser(ctx context.Context, databaseName, userName string, params *UserParams) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("altering the user", "database", databaseName, "name", userName)
+	stmt := buildUserSQL("ALTER", userName, params)
+	if stmt == "" {
+		return nil
+	}
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return execInDatabase(ctx, sqlDB, databaseName, stmt)
+}
+
+// DeleteUser drops the user from the database if it exists
+func (db *MSSql) DeleteUser(ctx context.Context, databaseName, userName string) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("deleting the user", "database", databaseName, "name", userName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return execInDatabase(ctx, sqlDB, databaseName, fmt.Sprintf("DROP USER IF EXISTS %s;", QuoteName(userName)))
+}
+
+// UpdateRoleMembership adds the member to and drops it from the given database roles
+func (db *MSSql) UpdateRoleMembership(ctx context.Context, databaseName, memberName string, add, drop []string) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	if len(add) == 0 && len(drop) == 0 {
+		return nil
+	}
+	logger.Info("updating role membership", "database", databaseName, "member", memberName, "add", add, "drop", drop)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, role := range add {
+		if err := execInDatabase(ctx, sqlDB, databaseName, fmt.Sprintf("ALTER ROLE %s ADD MEMBER %s;", QuoteName(role), QuoteName(memberName))); err != nil {
+			return err
+		}
+	}
+	for _, role := range drop {
+		if err := execInDatabase(ctx, sqlDB, databaseName, fmt.Sprintf("ALTER ROLE %s DROP MEMBER %s;", QuoteName(role), QuoteName(memberName))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildUserSQL(verb string, userName string, params *UserParams) string {
+	options := []string{}
+
+	if params.LoginName != nil {
+		if verb == "CREATE" {
+			return fmt.Sprintf("CREATE USER %s FOR LOGIN %s%s;", QuoteName(userName), QuoteName(*params.LoginName), defaultSchemaOption(" WITH ", params))
+		}
+		options = append(options, fmt.Sprintf("LOGIN = %s", QuoteName(*params.LoginName)))
+	}
+	if params.Password != nil {
+		options = append(options, fmt.Sprintf("PASSWORD = %s", QuoteString(*params.Password)))
+	}
+	if schema := defaultSchemaOption("", params); schema != "" {
+		options = append(options, schema)
+	}
+	if len(options) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s USER %s WITH %s;", verb, QuoteName(userName), strings.Join(options, ", "))
+}
+
+func defaultSchemaOption(prefix string, params *UserParams) string {
+	if params.DefaultSchema == nil || *params.DefaultSchema == "" {
+		return ""
+	}
+	return fmt.Sprintf("%sDEFAULT_SCHEMA = %s", prefix, QuoteName(*params.DefaultSchema))
+}
+
+// Permission is a single GRANT or DENY of a permission to a principal on a securable
+type Permission struct {
+	Principal  string
+	State      string
+	Permission string
+	// Class is one of DATABASE, SCHEMA or OBJECT
+	Class string
+	// Securable is empty for the database, the schema name or `schema.object`
+	Securable string
+}
+
+// RoleMembership is the membership of a principal in a database role
+type RoleMembership struct {
+	Role   string
+	Member string
+}
+
+type PermissionConfig struct {
+	DatabaseName string
+	// Roles whose membership is managed, they are created when missing
+	Roles []string
+	// Memberships the desired members of the managed roles
+	Memberships []RoleMembership
+	// ManagedMemberships the memberships granted before, those no longer desired are dropped while members added
+	// by others are left alone
+	ManagedMemberships []RoleMembership
+	// Principals whose permissions are managed
+	Principals  []string
+	Permissions []Permission
+}
+
+type PermissionSyncResponse struct {
+	CreateRoles []string
+	AddMembers  []RoleMembership
+	DropMembers []RoleMembership
+	Apply       []Permission
+	Revoke      []Permission
+}
+
+var permissionPattern = regexp.MustCompile(`^[A-Z]+( [A-Z]+)*$`)
+
+// ValidatePermission normalizes a permission name, permissions are keywords and can not be quoted
+func ValidatePermission(permission string) (string, error) {
+	p := strings.ToUpper(strings.Join(strings.Fields(permission), " "))
+	if !permissionPattern.MatchString(p) {
+		return "", invalidRequest("invalid permission: %q", permission)
+	}
+	return p, nil
+}
+
+// permissionKey identifies a permission, names are compared case insensitively like the default collation of sql
+// server does, so a securable written in another case than the catalog is not revoked and granted on every sync
+func permissionKey(p Permission) string {
+	return strings.Join([]string{strings.ToUpper(p.Principal), p.Class, strings.ToUpper(p.Securable), p.Permission}, "|")
+}
+
+// implicitPermission is granted by sql server when a user is created and never revoked
+func implicitPermission(p Permission) bool {
+	return p.Class == "DATABASE" && p.Permission == "CONNECT"
+}
+
+// PermissionSyncNeeded compares the roles, role members and permissions in the database with the desired
+// config, returns nil when nothing differs
+func (db *MSSql) PermissionSyncNeeded(ctx context.Context, params *PermissionConfig) (*PermissionSyncResponse, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.V(1).Info("determine permission syncing", "database", params.DatabaseName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	qdb := QuoteName(params.DatabaseName)
+	syncResponse := &PermissionSyncResponse{}
+	requireSync := false
+
+	/***************************************************************************************************************************
+	* Roles and role membership
+	***************************************************************************************************************************/
+	existingRoles := map[string]bool{}
+	rows, err := sqlDB.QueryContext(ctx, fmt.Sprintf("SELECT [name] FROM %s.sys.database_principals WHERE [type] = 'R'", qdb))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var role string
+		if err = rows.Scan(&role); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		existingRoles[role] = true
+	}
+	rows.Close()
+
+	for _, role := range params.Roles {
+		if !existingRoles[role] {
+			syncResponse.CreateRoles = append(syncResponse.CreateRoles, role)
+			requireSync = true
+		}
+	}
+
+	actualMembers := map[RoleMembership]bool{}
+	rows, err = sqlDB.QueryContext(ctx, fmt.Sprintf("SELECT r.[name], m.[name] FROM %s.sys.database_role_members rm "+
+		"JOIN %s.sys.database_principals r ON rm.[role_principal_id] = r.[principal_id] "+
+		"JOIN %s.sys.database_principals m ON rm.[member_principal_id] = m.[principal_id]", qdb, qdb, qdb))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var m RoleMembership
+		if err = rows.Scan(&m.Role, &m.Member); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		actualMembers[m] = true
+	}
+	rows.Close()
+
+	desiredMembers := map[RoleMembership]bool{}
+	for _, m := range params.Memberships {
+		desiredMembers[m] = true
+		if !actualMembers[m] {
+			syncResponse.AddMembers = append(syncResponse.AddMembers, m)
+			requireSync = true
+		}
+	}
+	for _, m := range params.ManagedMemberships {
+		if !desiredMembers[m] && actualMembers[m] && m.Member != "dbo" {
+			syncResponse.DropMembers = append(syncResponse.DropMembers, m)
+			requireSync = true
+		}
+	}
+
+	/***************************************************************************************************************************
+	* Permissions of the managed principals
+	***************************************************************************************************************************/
+	managedPrincipals := map[string]bool{}
+	for _, p := range params.Principals {
+		managedPrincipals[strings.ToUpper(p)] = true
+	}
+	actual := map[string]Permission{}
+	rows, err = sqlDB.QueryContext(ctx, fmt.Sprintf("SELECT pr.[name], IIF(perm.[state] = 'D', 'DENY', 'GRANT'), perm.[permission_name], "+
+		"CASE perm.[class] WHEN 0 THEN 'DATABASE' WHEN 3 THEN 'SCHEMA' ELSE 'OBJECT' END, "+
+		"CASE perm.[class] WHEN 0 THEN '' WHEN 3 THEN s.[name] ELSE os.[name] + '.' + o.[name] END "+
+		"FROM %s.sys.database_permissions perm "+
+		"JOIN %s.sys.database_principals pr ON perm.[grantee_principal_id] = pr.[principal_id] "+
+		"LEFT JOIN %s.sys.schemas s ON perm.[class] = 3 AND perm.[major_id] = s.[schema_id] "+
+		"LEFT JOIN %s.sys.objects o ON perm.[class] = 1 AND perm.[major_id] = o.[object_id] "+
+		"LEFT JOIN %s.sys.schemas os ON o.[schema_id] = os.[schema_id] "+
+		"WHERE perm.[class] IN (0, 1, 3) AND perm.[minor_id] = 0", qdb, qdb, qdb, qdb, qdb))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var p Permission
+		if err = rows.Scan(&p.Principal, &p.State, &p.Permission, &p.Class, &p.Securable); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if managedPrincipals[strings.ToUpper(p.Principal)] {
+			actual[permissionKey(p)] = p
+		}
+	}
+	rows.Close()
+
+	desired := map[string]bool{}
+	for _, p := range params.Permissions {
+		key := permissionKey(p)
+		desired[key] = true
+		if current, ok := actual[key]; !ok || current.State != p.State {
+			syncResponse.Apply = append(syncResponse.Apply, p)
+			requireSync = true
+		}
+	}
+	for key, p := range actual {
+		if !desired[key] && !implicitPermission(p) {
+			syncResponse.Revoke = append(syncResponse.Revoke, p)
+			requireSync = true
+		}
+	}
+	/**************************************************************************************************************************/
+	if requireSync {
+		return syncResponse, nil
+	}
+	return nil, nil
+}
+
+// Statements the statements that bring the database in line with the desired permissions
+func (s *PermissionSyncResponse) Statements() []string {
+	statements := []string{}
+	for _, role := range s.CreateRoles {
+		statements = append(statements, fmt.Sprintf("CREATE ROLE %s;", QuoteName(role)))
+	}
+	for _, m := range s.DropMembers {
+		statements = append(statements, fmt.Sprintf("ALTER ROLE %s DROP MEMBER %s;", QuoteName(m.Role), QuoteName(m.Member)))
+	}
+	for _, m := range s.AddMembers {
+		statements = append(statements, fmt.Sprintf("ALTER ROLE %s ADD MEMBER %s;", QuoteName(m.Role), QuoteName(m.Member)))
+	}
+	for _, p := range s.Revoke {
+		statements = append(statements, fmt.Sprintf("REVOKE %s%s FROM %s;", p.Permission, securableClause(p), QuoteName(p.Principal)))
+	}
+	for _, p := range s.Apply {
+		statements = append(statements, fmt.Sprintf("%s %s%s TO %s;", p.State, p.Permission, securableClause(p), QuoteName(p.Principal)))
+	}
+	return statements
+}
+
+func securableClause(p Permission) string {
+	switch p.Class {
+	case "SCHEMA":
+		return fmt.Sprintf(" ON SCHEMA::%s", QuoteName(p.Securable))
+	case "OBJECT":
+		parts := strings.SplitN(p.Securable, ".", 2)
+		if len(parts) == 1 {
+			return fmt.Sprintf(" ON OBJECT::%s", QuoteName(parts[0]))
+		}
+		return fmt.Sprintf(" ON OBJECT::%s.%s", QuoteName(parts[0]), QuoteName(parts[1]))
+	}
+	return ""
+}
+
+// ApplyPermissions runs the statements of the sync response inside the database
+func (db *MSSql) ApplyPermissions(ctx context.Context, databaseName string, syncResponse *PermissionSyncResponse) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("applying permissions", "database", databaseName)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, stmt := range syncResponse.Statements() {
+		if err := execInDatabase(ctx, sqlDB, databaseName, stmt); err != nil {
+			return fmt.Errorf("failed to run: %s, error: %w", stmt, err)
+		}
+	}
+	return nil
+}
+
+// DropRoles removes every member from the roles and drops them
+func (db *MSSql) DropRoles(ctx context.Context, databaseName string, roles []string) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	if len(roles) == 0 {
+		return nil
+	}
+	logger.Info("dropping roles", "database", databaseName, "roles", roles)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	for _, role := range roles {
+		stmt := fmt.Sprintf("DECLARE @member sysname, @sql nvarchar(max); "+
+			"IF DATABASE_PRINCIPAL_ID(%[1]s) IS NOT NULL BEGIN "+
+			"DECLARE members CURSOR LOCAL FAST_FORWARD FOR SELECT m.[name] FROM sys.database_role_members rm "+
+			"JOIN sys.database_principals m ON rm.[member_principal_id] = m.[principal_id] WHERE rm.[role_principal_id] = DATABASE_PRINCIPAL_ID(%[1]s); "+
+			"OPEN members; FETCH NEXT FROM members INTO @member; "+
+			"WHILE @@FETCH_STATUS = 0 BEGIN SET @sql = N'ALTER ROLE ' + QUOTENAME(%[1]s) + N' DROP MEMBER ' + QUOTENAME(@member); EXEC (@sql); FETCH NEXT FROM members INTO @member; END; "+
+			"CLOSE members; DEALLOCATE members; DROP ROLE %[2]s; END;", QuoteString(role), QuoteName(role))
+		if err := execInDatabase(ctx, sqlDB, databaseName, stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	BackupFull         = "FULL"
+	BackupDifferential = "DIFFERENTIAL"
+	BackupLog          = "LOG"
+)
+
+type BackupParams struct {
+	// Type is one of FULL, DIFFERENTIAL or LOG
+	Type string
+	// Disk path of the backup file, mutually exclusive with URL
+	Disk string
+	// URL of the backup in blob storage, mutually exclusive with Disk
+	URL         string
+	CopyOnly    bool
+	Compression *bool
+}
+
+// BackupResult the backup set recorded in msdb for a backup
+type BackupResult struct {
+	StartTime      time.Time
+	FinishTime     time.Time
+	Size           int64
+	CompressedSize int64
+	FirstLSN       string
+	LastLSN        string
+}
+
+// BackupDatabase backs the database up and returns the backup set it wrote
+func (db *MSSql) BackupDatabase(ctx context.Context, databaseName string, params *BackupParams) (*BackupResult, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("backing up the database", "name", databaseName, "type", params.Type)
+	stmt, err := buildBackupSQL(databaseName, params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Backup)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if _, err = sqlDB.ExecContext(ctx, stmt); err != nil {
+		return nil, err
+	}
+	return recordedBackup(ctx, sqlDB, databaseName, params)
+}
+
+// BackupOperation a backup running in the background on a dedicated session
+type BackupOperation struct {
+	// SessionID @@SPID of the session running the backup
+	SessionID int
+
+	done   chan struct{}
+	result *BackupResult
+	err    error
+}
+
+// Done reports whether the backup finished
+func (o *BackupOperation) Done() bool {
+	select {
+	case <-o.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Result the backup set the backup wrote or the error it finished with, only meaningful once Done
+func (o *BackupOperation) Result() (*BackupResult, error) {
+	if !o.Done() {
+		return nil, nil
+	}
+	return o.result, o.err
+}
+
+// StartBackup starts backing the database up on a session of its own and returns without waiting for it
+func (db *MSSql) StartBackup(ctx context.Context, databaseName string, params *BackupParams) (*BackupOperation, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("backing up the database", "name", databaseName, "type", params.Type)
+	stmt, err := buildBackupSQL(databaseName, params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	// the backup outlives this call on a session it holds until it finishes or runs out of time, the pool is released
+	// along with it
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	backupCtx, backupCancel := db.withTimeout(context.Background(), db.timeouts().Backup)
+
+	op := &BackupOperation{done: make(chan struct{})}
+	if err = conn.QueryRowContext(ctx, "SELECT @@SPID").Scan(&op.SessionID); err != nil {
+		backupCancel()
+		conn.Close()
+		release()
+		return nil, err
+	}
+
+	go func() {
+		defer close(op.done)
+		defer release()
+		defer backupCancel()
+		defer conn.Close()
+		if _, err := conn.ExecContext(backupCtx, stmt); err != nil {
+			logger.Error(err, "backup failed", "name", databaseName)
+			op.err = err
+			return
+		}
+		op.result, op.err = recordedBackup(backupCtx, conn, databaseName, params)
+		logger.Info("backup finished", "name", databaseName)
+	}()
+	return op, nil
+}
+
+// BackupProgress percent complete of the backup running on the session, nil when the session is not backing up
+func (db *MSSql) BackupProgress(ctx context.Context, sessionID int) (*float64, error) {
+	return db.requestProgress(ctx, sessionID, "BACKUP%")
+}
+
+// BackupRecorded the backup set msdb recorded for the latest backup of the database to the destination of params,
+// nil when no backup started after since
+func (db *MSSql) BackupRecorded(ctx context.Context, databaseName string, params *BackupParams, since time.Time) (*BackupResult, error) {
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	result, err := recordedBackup(ctx, sqlDB, databaseName, params)
+	if err != nil {
+		if _, ok := err.(*backupNotRecorded); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if result.StartTime.Before(since) {
+		return nil, nil
+	}
+	return result, nil
+}
+
+// backupNotRecorded the backup finished without msdb recording a backup set for it
+type backupNotRecorded struct {
+	databaseName string
+	device       string
+}
+
+func (e *backupNotRecorded) Error() string {
+	return fmt.Sprintf("backup of database: %s to: %s was not recorded in msdb", e.databaseName, e.device)
+}
+
+// rowQuerier a pool or a single session of it
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// recordedBackup the backup set msdb recorded for the latest backup of the database to the destination of params
+func recordedBackup(ctx context.Context, q rowQuerier, databaseName string, params *BackupParams) (*BackupResult, error) {
+	result := &BackupResult{}
+	err := q.QueryRowContext(ctx, "SELECT TOP 1 bs.[backup_start_date], bs.[backup_finish_date], "+
+		"CAST(bs.[backup_size] AS bigint), CAST(ISNULL(bs.[compressed_backup_size], bs.[backup_size]) AS bigint), "+
+		"CONVERT(varchar(25), bs.[first_lsn]), CONVERT(varchar(25), bs.[last_lsn]) "+
+		"FROM msdb.dbo.backupset bs JOIN msdb.dbo.backupmediafamily mf ON bs.[media_set_id] = mf.[media_set_id] "+
+		"WHERE bs.[database_name] = @p1 AND mf.[physical_device_name] = @p2 "+
+		"ORDER BY bs.[backup_set_id] DESC", databaseName, backupDevice(params)).Scan(
+		&result.StartTime, &result.FinishTime, &result.Size, &result.CompressedSize, &result.FirstLSN, &result.LastLSN)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &backupNotRecorded{databaseName: databaseName, device: backupDevice(params)}
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteBackupFile removes a backup file written to disk by the instance
+func (db *MSSql) DeleteBackupFile(ctx context.Context, path string) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("deleting the backup file", "path", path)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	_, err = sqlDB.ExecContext(ctx, "EXEC master.sys.xp_delete_file 0, @p1", path)
+	return err
+}
+
+// DefaultBackupPath the default backup directory of the instance
+func (db *MSSql) DefaultBackupPath(ctx context.Context) (string, error) {
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	var path string
+	if err := sqlDB.QueryRowContext(ctx, "SELECT ISNULL(CAST(SERVERPROPERTY('InstanceDefaultBackupPath') AS nvarchar(512)), '')").Scan(&path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func backupDevice(params *BackupParams) string {
+	if params.URL != "" {
+		return params.URL
+	}
+	return params.Disk
+}
+
+func buildBackupSQL(databaseName string, params *BackupParams) (string, error) {
+	var b strings.Builder
+
+	switch params.Type {
+	case BackupFull, BackupDifferential:
+		fmt.Fprintf(&b, "BACKUP DATABASE %s ", QuoteName(databaseName))
+	case BackupLog:
+		fmt.Fprintf(&b, "BACKUP LOG %s ", QuoteName(databaseName))
+	default:
+		return "", invalidRequest("invalid backup type: %q", params.Type)
+	}
+
+	switch {
+	case params.Disk != "" && params.URL == "":
+		fmt.Fprintf(&b, "TO DISK = %s", QuoteString(params.Disk))
+	case params.URL != "" && params.Disk == "":
+		fmt.Fprintf(&b, "TO URL = %s", QuoteString(params.URL))
+	default:
+		return "", invalidRequest("exactly one of disk or url is required to back up database: %s", databaseName)
+	}
+
+	options := []string{}
+	if params.Type == BackupDifferential {
+		options = append(options, "DIFFERENTIAL")
+	}
+	if params.CopyOnly {
+		options = append(options, "COPY_ONLY")
+	}
+	if params.Compression != nil {
+		if *params.Compression {
+			options = append(options, "COMPRESSION")
+		} else {
+			options = append(options, "NO_COMPRESSION")
+		}
+	}
+	options = append(options, "CHECKSUM")
+	fmt.Fprintf(&b, " WITH %s;", strings.Join(options, ", "))
+
+	return b.String(), nil
+}
+
+// RestoreMedia the backup file a restore reads, exactly one of Disk and URL
+type RestoreMedia struct {
+	Disk string
+	URL  string
+}
+
+// RestoreMove relocates a database file while restoring
+type RestoreMove struct {
+	LogicalName  string
+	PhysicalName string
+}
+
+type RestoreParams struct {
+	// From is the full database backup
+	From RestoreMedia
+	// Logs are transaction log backups applied in order after From
+	Logs    []RestoreMedia
+	Move    []RestoreMove
+	Replace bool
+	// StopAt recovers the database to a point in time, in the time zone of the instance
+	StopAt *time.Time
+}
+
+// RestoreOperation a restore running in the background on a dedicated session
+type RestoreOperation struct {
+	// SessionID @@SPID of the session running the restore
+	SessionID int
+
+	done chan struct{}
+	err  error
+}
+
+// Done reports whether the restore finished
+func (o *RestoreOperation) Done() bool {
+	select {
+	case <-o.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err the error the restore finished with, only meaningful once Done
+func (o *RestoreOperation) Err() error {
+	if !o.Done() {
+		return nil
+	}
+	return o.err
+}
+
+// StartRestore starts restoring the database on a session of its own and returns without waiting for it
+func (db *MSSql) StartRestore(ctx context.Context, databaseName string, params *RestoreParams) (*RestoreOperation, error) {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("restoring the database", "name", databaseName)
+	stmts, err := buildRestoreSQL(databaseName, params)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	// the restore outlives this call on a session it holds until it finishes or runs out of time, the pool is released
+	// along with it
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	restoreCtx, restoreCancel := db.withTimeout(context.Background(), db.timeouts().Restore)
+
+	op := &RestoreOperation{done: make(chan struct{})}
+	if err = conn.QueryRowContext(ctx, "SELECT @@SPID").Scan(&op.SessionID); err != nil {
+		restoreCancel()
+		conn.Close()
+		release()
+		return nil, err
+	}
+
+	go func() {
+		defer close(op.done)
+		defer release()
+		defer restoreCancel()
+		defer conn.Close()
+		for _, stmt := range stmts {
+			if _, err := conn.ExecContext(restoreCtx, stmt); err != nil {
+				logger.Error(err, "restore failed", "name", databaseName)
+				op.err = err
+				return
+			}
+		}
+		logger.Info("restore finished", "name", databaseName)
+	}()
+	return op, nil
+}
+
+// RestoreProgress percent complete of the restore running on the session, nil when the session is not restoring
+func (db *MSSql) RestoreProgress(ctx context.Context, sessionID int) (*float64, error) {
+	return db.requestProgress(ctx, sessionID, "RESTORE%")
+}
+
+// requestProgress percent complete of the request running on the session, nil when the session runs no request
+// matching the command pattern
+func (db *MSSql) requestProgress(ctx context.Context, sessionID int, command string) (*float64, error) {
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var percent float64
+	err = sqlDB.QueryRowContext(ctx, "SELECT CAST([percent_complete] AS float) FROM sys.dm_exec_requests "+
+		"WHERE [session_id] = @p1 AND [command] LIKE @p2", sessionID, command).Scan(&percent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &percent, nil
+}
+
+// RestoresRecorded the number of restores into the database msdb recorded since the given time, one for the full
+// backup and one for every log backup applied after it
+func (db *MSSql) RestoresRecorded(ctx context.Context, databaseName string, since time.Time) (int, error) {
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	var count int
+	err = sqlDB.QueryRowContext(ctx, "SELECT COUNT(*) FROM msdb.dbo.restorehistory "+
+		"WHERE [destination_database_name] = @p1 AND [restore_date] >= @p2", databaseName, since).Scan(&count)
+	return count, err
+}
+
+// CancelRestore kills the session when it is still running a restore, a session that moved on is left alone
+func (db *MSSql) CancelRestore(ctx context.Context, sessionID int) error {
+	_ = log.FromContext(ctx)
+	logger := log.Log
+
+	logger.Info("cancelling the restore", "session-id", sessionID)
+	ctx, cancel := db.withTimeout(ctx, db.timeouts().Statement)
+	defer cancel()
+	sqlDB, release, err := db.connection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// KILL only takes a literal, the session id is an int
+	_, err = sqlDB.ExecContext(ctx, fmt.Sprintf("IF EXISTS (SELECT 1 FROM sys.dm_exec_requests "+
+		"WHERE [session_id] = @p1 AND [command] LIKE 'RESTORE%%') KILL %d;", sessionID), sessionID)
+	return err
+}
+
+func restoreSource(media RestoreMedia) (string, error) {
+	switch {
+	case media.Disk != "" && media.URL == "":
+		return fmt.Sprintf("FROM DISK = %s", QuoteString(media.Disk)), nil
+	case media.URL != "" && media.Disk == "":
+		return fmt.Sprintf("FROM URL = %s", QuoteString(media.URL)), nil
+	}
+	return "", invalidRequest("exactly one of disk or url is required for a backup to restore from")
+}
+
+func buildRestoreSQL(databaseName string, params *RestoreParams) ([]string, error) {
+	stmts := []string{}
+
+	from, err := restoreSource(params.From)
+	if err != nil {
+		return nil, err
+	}
+	options := []string{}
+	for _, move := range params.Move {
+		if move.LogicalName == "" || move.PhysicalName == "" {
+			return nil, invalidRequest("a file move needs both the logical and physical name")
+		}
+		options = append(options, fmt.Sprintf("MOVE %s TO %s", QuoteString(move.LogicalName), QuoteString(move.PhysicalName)))
+	}
+	if params.Replace {
+		options = append(options, "REPLACE")
+	}
+	stopAt := ""
+	if params.StopAt != nil {
+		stopAt = fmt.Sprintf("STOPAT = %s", QuoteString(params.StopAt.Format("2006-01-02T15:04:05")))
+	}
+
+	if len(params.Logs) == 0 {
+		if stopAt != "" {
+			options = append(options, stopAt)
+		}
+		options = append(options, "RECOVERY")
+		return append(stmts, fmt.Sprintf("RESTORE DATABASE %s %s WITH %s;", QuoteName(databaseName), from, strings.Join(options, ", "))), nil
+	}
+
+	options = append(options, "NORECOVERY")
+	stmts = append(stmts, fmt.Sprintf("RESTORE DATABASE %s %s WITH %s;", QuoteName(databaseName), from, strings.Join(options, ", ")))
+	for _, media := range params.Logs {
+		from, err := restoreSource(media)
+		if err != nil {
+			return nil, err
+		}
+		logOptions := []string{}
+		if stopAt != "" {
+			logOptions = append(logOptions, stopAt)
+		}
+		logOptions = append(logOptions, "NORECOVERY")
+		stmts = append(stmts, fmt.Sprintf("RESTORE LOG %s %s WITH %s;", QuoteName(databaseName), from, strings.Join(logOptions, ", ")))
+	}
+	return append(stmts, fmt.Sprintf("RESTORE DATABASE %s WITH RECOVERY;", QuoteName(databaseName))), nil
 }
@@ -0,0 +1,936 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+func TestBuildBackupSQL(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name    string
+		params  BackupParams
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "full to disk",
+			params: BackupParams{Type: BackupFull, Disk: "/var/opt/mssql/backups/db.bak"},
+			want:   "BACKUP DATABASE [my]]db] TO DISK = N'/var/opt/mssql/backups/db.bak' WITH CHECKSUM;",
+		},
+		{
+			name:   "differential copy only compressed to url",
+			params: BackupParams{Type: BackupDifferential, URL: "https://acct.blob.core.windows.net/c/db'1.bak", CopyOnly: true, Compression: &on},
+			want:   "BACKUP DATABASE [my]]db] TO URL = N'https://acct.blob.core.windows.net/c/db''1.bak' WITH DIFFERENTIAL, COPY_ONLY, COMPRESSION, CHECKSUM;",
+		},
+		{
+			name:   "log without compression",
+			params: BackupParams{Type: BackupLog, Disk: "/backups/db.trn", Compression: &off},
+			want:   "BACKUP LOG [my]]db] TO DISK = N'/backups/db.trn' WITH NO_COMPRESSION, CHECKSUM;",
+		},
+		{
+			name:    "unknown type",
+			params:  BackupParams{Type: "SNAPSHOT", Disk: "/backups/db.bak"},
+			wantErr: true,
+		},
+		{
+			name:    "no destination",
+			params:  BackupParams{Type: BackupFull},
+			wantErr: true,
+		},
+		{
+			name:    "both destinations",
+			params:  BackupParams{Type: BackupFull, Disk: "/backups/db.bak", URL: "https://acct.blob.core.windows.net/c/db.bak"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildBackupSQL("my]db", &tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildBackupSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("buildBackupSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackupDatabase(t *testing.T) {
+	start := time.Date(2021, 7, 1, 10, 0, 0, 0, time.UTC)
+	finish := start.Add(42 * time.Second)
+
+	var lookupArgs []driver.NamedValue
+	server, done := newStandInServer("backup-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		if !strings.Contains(query, "msdb.dbo.backupset") {
+			return nil, nil
+		}
+		lookupArgs = args
+		return &standInRows{
+			columns: []string{"backup_start_date", "backup_finish_date", "backup_size", "compressed_backup_size", "first_lsn", "last_lsn"},
+			values:  [][]driver.Value{{start, finish, int64(4096), int64(1024), "37000000012800001", "37000000014400001"}},
+		}, nil
+	})
+	defer done()
+
+	db := NewMSSql("backup-server", "sa", "secret", 1433)
+	result, err := db.BackupDatabase(context.Background(), "sales", &BackupParams{Type: BackupFull, Disk: "/backups/sales.bak"})
+	if err != nil {
+		t.Fatalf("BackupDatabase() error = %v", err)
+	}
+
+	statements := server.Statements()
+	if len(statements) != 2 || statements[0] != "BACKUP DATABASE [sales] TO DISK = N'/backups/sales.bak' WITH CHECKSUM;" {
+		t.Fatalf("unexpected statements: %q", statements)
+	}
+	if len(lookupArgs) != 2 || lookupArgs[0].Value != "sales" || lookupArgs[1].Value != "/backups/sales.bak" {
+		t.Errorf("backup set looked up with %v", lookupArgs)
+	}
+	if !result.StartTime.Equal(start) || !result.FinishTime.Equal(finish) {
+		t.Errorf("unexpected times: %v - %v", result.StartTime, result.FinishTime)
+	}
+	if result.Size != 4096 || result.CompressedSize != 1024 {
+		t.Errorf("unexpected sizes: %d, %d", result.Size, result.CompressedSize)
+	}
+	if result.FirstLSN != "37000000012800001" || result.LastLSN != "37000000014400001" {
+		t.Errorf("unexpected lsn range: %s - %s", result.FirstLSN, result.LastLSN)
+	}
+}
+
+func TestStartBackup(t *testing.T) {
+	start := time.Date(2021, 7, 1, 10, 0, 0, 0, time.UTC)
+	release := make(chan struct{})
+	var progressArgs []driver.NamedValue
+	_, done := newStandInServer("async-backup-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		switch {
+		case query == "SELECT @@SPID":
+			return &standInRows{columns: []string{""}, values: [][]driver.Value{{int64(61)}}}, nil
+		case strings.Contains(query, "sys.dm_exec_requests"):
+			progressArgs = args
+			return &standInRows{columns: []string{"percent_complete"}, values: [][]driver.Value{{float64(12.5)}}}, nil
+		case strings.HasPrefix(query, "BACKUP"):
+			<-release
+		case strings.Contains(query, "msdb.dbo.backupset"):
+			return &standInRows{
+				columns: []string{"backup_start_date", "backup_finish_date", "backup_size", "compressed_backup_size", "first_lsn", "last_lsn"},
+				values:  [][]driver.Value{{start, start.Add(time.Hour), int64(4096), int64(1024), "37000000012800001", "37000000014400001"}},
+			}, nil
+		}
+		return nil, nil
+	})
+	defer done()
+
+	db := NewMSSql("async-backup-server", "sa", "secret", 1433)
+	params := &BackupParams{Type: BackupFull, Disk: "/backups/sales.bak"}
+	op, err := db.StartBackup(context.Background(), "sales", params)
+	if err != nil {
+		t.Fatalf("StartBackup() error = %v", err)
+	}
+	if op.SessionID != 61 || op.Done() {
+		t.Fatalf("StartBackup() = session %d done %v, want session 61 still running", op.SessionID, op.Done())
+	}
+	if result, err := op.Result(); result != nil || err != nil {
+		t.Errorf("Result() of a running backup = %v, %v", result, err)
+	}
+
+	progress, err := db.BackupProgress(context.Background(), op.SessionID)
+	if err != nil || progress == nil || *progress != 12.5 {
+		t.Fatalf("BackupProgress() = %v, %v", progress, err)
+	}
+	if len(progressArgs) != 2 || progressArgs[1].Value != "BACKUP%" {
+		t.Errorf("BackupProgress() looked up with %v", progressArgs)
+	}
+
+	close(release)
+	<-op.done
+	result, err := op.Result()
+	if err != nil || result == nil || !result.StartTime.Equal(start) {
+		t.Fatalf("Result() = %v, %v", result, err)
+	}
+
+	if recorded, err := db.BackupRecorded(context.Background(), "sales", params, start.Add(-time.Minute)); err != nil || recorded == nil {
+		t.Errorf("BackupRecorded() = %v, %v, want the backup set", recorded, err)
+	}
+	if recorded, err := db.BackupRecorded(context.Background(), "sales", params, start.Add(time.Minute)); err != nil || recorded != nil {
+		t.Errorf("BackupRecorded() of an older backup set = %v, %v, want none", recorded, err)
+	}
+}
+
+func TestBackupDatabaseNotRecorded(t *testing.T) {
+	_, done := newStandInServer("unrecorded-server", nil)
+	defer done()
+
+	db := NewMSSql("unrecorded-server", "sa", "secret", 1433)
+	if _, err := db.BackupDatabase(context.Background(), "sales", &BackupParams{Type: BackupLog, Disk: "/backups/sales.trn"}); err == nil {
+		t.Fatal("expected an error when msdb has no backup set")
+	}
+}
+
+func TestBuildRestoreSQL(t *testing.T) {
+	stopAt := time.Date(2021, 7, 1, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		params  RestoreParams
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "full",
+			params: RestoreParams{From: RestoreMedia{Disk: "/backups/prod.bak"}},
+			want:   []string{"RESTORE DATABASE [staging] FROM DISK = N'/backups/prod.bak' WITH RECOVERY;"},
+		},
+		{
+			name: "replace with moved files at a point in time",
+			params: RestoreParams{
+				From:    RestoreMedia{URL: "https://acct.blob.core.windows.net/c/prod.bak"},
+				Move:    []RestoreMove{{LogicalName: "prod", PhysicalName: "/var/opt/mssql/data/staging.mdf"}, {LogicalName: "prod_log", PhysicalName: "/var/opt/mssql/data/staging_log.ldf"}},
+				Replace: true,
+				StopAt:  &stopAt,
+			},
+			want: []string{"RESTORE DATABASE [staging] FROM URL = N'https://acct.blob.core.windows.net/c/prod.bak' WITH " +
+				"MOVE N'prod' TO N'/var/opt/mssql/data/staging.mdf', MOVE N'prod_log' TO N'/var/opt/mssql/data/staging_log.ldf', " +
+				"REPLACE, STOPAT = N'2021-07-01T09:30:00', RECOVERY;"},
+		},
+		{
+			name: "full and logs",
+			params: RestoreParams{
+				From:   RestoreMedia{Disk: "/backups/prod.bak"},
+				Logs:   []RestoreMedia{{Disk: "/backups/prod-1.trn"}, {Disk: "/backups/prod-2.trn"}},
+				StopAt: &stopAt,
+			},
+			want: []string{
+				"RESTORE DATABASE [staging] FROM DISK = N'/backups/prod.bak' WITH NORECOVERY;",
+				"RESTORE LOG [staging] FROM DISK = N'/backups/prod-1.trn' WITH STOPAT = N'2021-07-01T09:30:00', NORECOVERY;",
+				"RESTORE LOG [staging] FROM DISK = N'/backups/prod-2.trn' WITH STOPAT = N'2021-07-01T09:30:00', NORECOVERY;",
+				"RESTORE DATABASE [staging] WITH RECOVERY;",
+			},
+		},
+		{
+			name:    "no source",
+			params:  RestoreParams{},
+			wantErr: true,
+		},
+		{
+			name:    "incomplete move",
+			params:  RestoreParams{From: RestoreMedia{Disk: "/backups/prod.bak"}, Move: []RestoreMove{{LogicalName: "prod"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildRestoreSQL("staging", &tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildRestoreSQL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("buildRestoreSQL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStartRestore(t *testing.T) {
+	release := make(chan struct{})
+	server, done := newStandInServer("restore-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		switch {
+		case query == "SELECT @@SPID":
+			return &standInRows{columns: []string{""}, values: [][]driver.Value{{int64(57)}}}, nil
+		case strings.Contains(query, "sys.dm_exec_requests"):
+			return &standInRows{columns: []string{"percent_complete"}, values: [][]driver.Value{{float64(42.5)}}}, nil
+		case strings.HasPrefix(query, "RESTORE"):
+			<-release
+		}
+		return nil, nil
+	})
+	defer done()
+
+	db := NewMSSql("restore-server", "sa", "secret", 1433)
+	op, err := db.StartRestore(context.Background(), "staging", &RestoreParams{From: RestoreMedia{Disk: "/backups/prod.bak"}})
+	if err != nil {
+		t.Fatalf("StartRestore() error = %v", err)
+	}
+	if op.SessionID != 57 {
+		t.Errorf("SessionID = %d, want 57", op.SessionID)
+	}
+	if op.Done() {
+		t.Fatal("restore finished before the server released it")
+	}
+
+	progress, err := NewMSSql("restore-server", "sa", "secret", 1433).RestoreProgress(context.Background(), op.SessionID)
+	if err != nil || progress == nil || *progress != 42.5 {
+		t.Fatalf("RestoreProgress() = %v, %v", progress, err)
+	}
+
+	close(release)
+	<-op.done
+	if op.Err() != nil {
+		t.Fatalf("restore failed: %v", op.Err())
+	}
+	restored := false
+	for _, stmt := range server.Statements() {
+		restored = restored || stmt == "RESTORE DATABASE [staging] FROM DISK = N'/backups/prod.bak' WITH RECOVERY;"
+	}
+	if !restored {
+		t.Errorf("restore was not run, statements: %q", server.Statements())
+	}
+}
+
+func TestRecoverRestore(t *testing.T) {
+	since := time.Date(2021, 7, 1, 9, 0, 0, 0, time.UTC)
+	var historyArgs []driver.NamedValue
+	server, done := newStandInServer("recover-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		if strings.Contains(query, "msdb.dbo.restorehistory") {
+			historyArgs = args
+			return &standInRows{columns: []string{""}, values: [][]driver.Value{{int64(2)}}}, nil
+		}
+		return nil, nil
+	})
+	defer done()
+
+	db := NewMSSql("recover-server", "sa", "secret", 1433)
+	recorded, err := db.RestoresRecorded(context.Background(), "staging", since)
+	if err != nil || recorded != 2 {
+		t.Fatalf("RestoresRecorded() = %d, %v, want 2", recorded, err)
+	}
+	if len(historyArgs) != 2 || historyArgs[0].Value != "staging" || historyArgs[1].Value != since {
+		t.Errorf("RestoresRecorded() looked up with %v", historyArgs)
+	}
+
+	if err := db.CancelRestore(context.Background(), 57); err != nil {
+		t.Fatalf("CancelRestore() error = %v", err)
+	}
+	want := "IF EXISTS (SELECT 1 FROM sys.dm_exec_requests WHERE [session_id] = @p1 AND [command] LIKE 'RESTORE%') KILL 57;"
+	if statements := server.Statements(); statements[len(statements)-1] != want {
+		t.Errorf("CancelRestore() ran %q, want %q", statements[len(statements)-1], want)
+	}
+}
+
+func TestDeleteBackupFile(t *testing.T) {
+	var deleted []driver.NamedValue
+	_, done := newStandInServer("prune-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		if query == "EXEC master.sys.xp_delete_file 0, @p1" {
+			deleted = args
+		}
+		return nil, nil
+	})
+	defer done()
+
+	db := NewMSSql("prune-server", "sa", "secret", 1433)
+	if err := db.DeleteBackupFile(context.Background(), "/backups/it's.bak"); err != nil {
+		t.Fatalf("DeleteBackupFile() error = %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].Value != "/backups/it's.bak" {
+		t.Errorf("xp_delete_file called with %v", deleted)
+	}
+}
+
+func TestDatabaseIsEmpty(t *testing.T) {
+	objects := int64(0)
+	server, done := newStandInServer("adoption-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		return &standInRows{columns: []string{""}, values: [][]driver.Value{{objects}}}, nil
+	})
+	defer done()
+
+	db := NewMSSql("adoption-server", "sa", "secret", 1433)
+	empty, err := db.DatabaseIsEmpty(context.Background(), "legacy]db")
+	if err != nil || !empty {
+		t.Fatalf("DatabaseIsEmpty() = %v, %v, want true", empty, err)
+	}
+	if got := server.Statements()[0]; got != "SELECT COUNT(*) FROM [legacy]]db].sys.objects WHERE [is_ms_shipped] = 0" {
+		t.Errorf("unexpected statement: %q", got)
+	}
+
+	objects = 3
+	if empty, err = db.DatabaseIsEmpty(context.Background(), "legacy]db"); err != nil || empty {
+		t.Fatalf("DatabaseIsEmpty() = %v, %v, want false", empty, err)
+	}
+}
+
+func TestRollbackAndDeleteDatabase(t *testing.T) {
+	server, done := newStandInServer("teardown-server", nil)
+	defer done()
+
+	if err := NewMSSql("teardown-server", "sa", "secret", 1433).RollbackAndDeleteDatabase(context.Background(), "ephemeral]db"); err != nil {
+		t.Fatalf("RollbackAndDeleteDatabase() error = %v", err)
+	}
+	want := "IF DB_ID(@p1) IS NOT NULL BEGIN ALTER DATABASE [ephemeral]]db] SET SINGLE_USER WITH ROLLBACK IMMEDIATE; DROP DATABASE [ephemeral]]db]; END"
+	if got := server.Statements(); len(got) != 1 || got[0] != want {
+		t.Errorf("statements = %q, want %q", got, want)
+	}
+}
+
+func TestDatabaseSessions(t *testing.T) {
+	_, done := newStandInServer("sessions-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		return &standInRows{
+			columns: []string{"session_id", "login_name", "host_name", "program_name", "status"},
+			values:  [][]driver.Value{{int64(53), "app", "web-0", "orders", "sleeping"}},
+		}, nil
+	})
+	defer done()
+
+	sessions, err := NewMSSql("sessions-server", "sa", "secret", 1433).DatabaseSessions(context.Background(), "ephemeral")
+	if err != nil {
+		t.Fatalf("DatabaseSessions() error = %v", err)
+	}
+	if len(sessions) != 1 || sessions[0] != (Session{SessionID: 53, LoginName: "app", HostName: "web-0", ProgramName: "orders", Status: "sleeping"}) {
+		t.Errorf("DatabaseSessions() = %+v", sessions)
+	}
+}
+
+func TestDatabaseDrift(t *testing.T) {
+	level, snapshot, parameterization := 150, false, "simple"
+	params := &DatabaseConfig{CompatibilityLevel: 160, AllowSnapshotIsolation: true, Parameterization: "forced"}
+
+	if drift := DatabaseDrift(params, nil); drift != nil {
+		t.Errorf("DatabaseDrift() without a sync response = %v, want none", drift)
+	}
+	drift := DatabaseDrift(params, &SyncResponse{CompatibilityLevel: &level, AllowSnapshotIsolation: &snapshot, Parameterization: &parameterization})
+	want := []FieldDrift{
+		{Field: "compatibilityLevel", Expected: "160", Actual: "150"},
+		{Field: "allowSnapshotIsolation", Expected: "true", Actual: "false"},
+		{Field: "parameterization", Expected: "forced", Actual: "simple"},
+	}
+	if len(drift) != len(want) {
+		t.Fatalf("DatabaseDrift() = %v, want %v", drift, want)
+	}
+	for i := range want {
+		if drift[i] != want[i] {
+			t.Errorf("DatabaseDrift()[%d] = %v, want %v", i, drift[i], want[i])
+		}
+	}
+}
+
+func TestSyncNeededOmittedSettings(t *testing.T) {
+	_, done := newStandInServer("omitted-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		return &standInRows{columns: []string{"database"}, values: [][]driver.Value{
+			{`{"database":[{"name":"sales","compatibilityLevel":150,"allowSnapshotIsolation":"false","parameterization":"simple"}]}`},
+		}}, nil
+	})
+	defer done()
+
+	db := NewMSSql("omitted-server", "sa", "secret", 1433)
+	syncResponse, err := db.SyncNeeded(context.Background(), &DatabaseConfig{DatabaseName: "sales"}, State)
+	if err != nil {
+		t.Fatalf("SyncNeeded() error = %v", err)
+	}
+	if syncResponse != nil {
+		t.Errorf("SyncNeeded() = %+v, want settings left out of the spec to keep the values of the instance", syncResponse)
+	}
+
+	syncResponse, err = db.SyncNeeded(context.Background(), &DatabaseConfig{DatabaseName: "sales", CompatibilityLevel: 160}, State)
+	if err != nil {
+		t.Fatalf("SyncNeeded() error = %v", err)
+	}
+	if syncResponse == nil || syncResponse.CompatibilityLevel == nil || *syncResponse.CompatibilityLevel != 160 || syncResponse.Parameterization != nil {
+		t.Errorf("SyncNeeded() = %+v, want only the compatibility level", syncResponse)
+	}
+}
+
+func TestHostileDatabaseNames(t *testing.T) {
+	tests := []struct {
+		name   string
+		quoted string
+	}{
+		{name: "sales", quoted: "[sales]"},
+		{name: "x; DROP DATABASE prod", quoted: "[x; DROP DATABASE prod]"},
+		{name: "a]b", quoted: "[a]]b]"},
+		{name: "[sales]", quoted: "[[sales]]]"},
+		{name: "O'Brien", quoted: "[O'Brien]"},
+		{name: "--", quoted: "[--]"},
+		{name: "x]; DROP DATABASE prod; --", quoted: "[x]]; DROP DATABASE prod; --]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			create, err := buildDatabaseSQL("CREATE", tt.name, &DatabaseParams{})
+			if err != nil {
+				t.Fatalf("buildDatabaseSQL() error = %v", err)
+			}
+			if want := "CREATE DATABASE " + tt.quoted + ";"; create != want {
+				t.Errorf("buildDatabaseSQL() = %q, want %q", create, want)
+			}
+
+			level := 150
+			alters, err := buildAlterSQL(tt.name, &DatabaseParams{CompatibilityLevel: &level})
+			if err != nil {
+				t.Fatalf("buildAlterSQL() error = %v", err)
+			}
+			if want := "ALTER DATABASE " + tt.quoted + " SET COMPATIBILITY_LEVEL = 150;"; len(alters) != 1 || alters[0].sql != want {
+				t.Errorf("buildAlterSQL() = %q, want %q", alters, want)
+			}
+
+			var lookupArgs []driver.NamedValue
+			server, done := newStandInServer("hostile-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+				lookupArgs = args
+				return nil, nil
+			})
+			defer done()
+
+			db := NewMSSql("hostile-server", "sa", "secret", 1433)
+			if err := db.DeleteDatabase(context.Background(), tt.name); err != nil {
+				t.Fatalf("DeleteDatabase() error = %v", err)
+			}
+			if want := "IF DB_ID(@p1) IS NOT NULL DROP DATABASE " + tt.quoted + ";"; server.Statements()[0] != want {
+				t.Errorf("DeleteDatabase() ran %q, want %q", server.Statements()[0], want)
+			}
+			if len(lookupArgs) != 1 || lookupArgs[0].Value != tt.name {
+				t.Errorf("DeleteDatabase() looked up with %v", lookupArgs)
+			}
+
+			if _, err := db.FindDatabaseID(context.Background(), tt.name); err != nil {
+				t.Fatalf("FindDatabaseID() error = %v", err)
+			}
+			if lookup := server.Statements()[1]; !strings.HasSuffix(lookup, "WHERE dbs.[name] = @p1") {
+				t.Errorf("FindDatabaseID() ran %q", lookup)
+			}
+			if len(lookupArgs) != 1 || lookupArgs[0].Value != tt.name {
+				t.Errorf("FindDatabaseID() looked up with %v", lookupArgs)
+			}
+		})
+	}
+}
+
+func TestDatabaseOptionsAllowList(t *testing.T) {
+	forced, upper, hostile := "forced", "Simple", "simple; DROP DATABASE prod"
+	supported, unsupported, omitted := 160, 90, 0
+	collation, badCollation := "SQL_Latin1_General_CP1_CS_AS", "Latin1_General_CI_AS; DROP DATABASE prod"
+
+	tests := []struct {
+		name    string
+		params  *DatabaseParams
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "forced parameterization",
+			params: &DatabaseParams{Parameterization: &forced},
+			want:   []string{"ALTER DATABASE [sales] SET PARAMETERIZATION FORCED;"},
+		},
+		{
+			name:   "parameterization is case insensitive",
+			params: &DatabaseParams{Parameterization: &upper},
+			want:   []string{"ALTER DATABASE [sales] SET PARAMETERIZATION SIMPLE;"},
+		},
+		{
+			name:    "unknown parameterization",
+			params:  &DatabaseParams{Parameterization: &hostile},
+			wantErr: true,
+		},
+		{
+			name:   "supported compatibility level",
+			params: &DatabaseParams{CompatibilityLevel: &supported},
+			want:   []string{"ALTER DATABASE [sales] SET COMPATIBILITY_LEVEL = 160;"},
+		},
+		{
+			name:    "unsupported compatibility level",
+			params:  &DatabaseParams{CompatibilityLevel: &unsupported},
+			wantErr: true,
+		},
+		{
+			name:   "omitted compatibility level",
+			params: &DatabaseParams{CompatibilityLevel: &omitted},
+			want:   nil,
+		},
+		{
+			name:   "collation",
+			params: &DatabaseParams{Collation: &collation},
+			want:   []string{"CREATE DATABASE [sales] COLLATE SQL_Latin1_General_CP1_CS_AS;"},
+		},
+		{
+			name:    "hostile collation",
+			params:  &DatabaseParams{Collation: &badCollation},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, done := newStandInServer("options-server", nil)
+			defer done()
+
+			_, _, err := NewMSSql("options-server", "sa", "secret", 1433).CreateDatabase(context.Background(), "sales", tt.params)
+			statements := server.Statements()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error for the invalid option")
+				}
+				if len(statements) != 0 {
+					t.Errorf("statements ran for an invalid option: %q", statements)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("CreateDatabase() error = %v", err)
+			}
+			var ddl []string
+			for _, stmt := range statements {
+				if strings.Contains(stmt, " DATABASE ") && !strings.HasPrefix(stmt, "CREATE DATABASE [sales];") {
+					ddl = append(ddl, stmt)
+				}
+			}
+			if strings.Join(ddl, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("CreateDatabase() ran %q, want %q", ddl, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionString(t *testing.T) {
+	db := NewMSSql("sqlmi-p-svc.arc.svc", "sa", "p@ss;database=master", 1433)
+	u, err := url.Parse(db.connectionString())
+	if err != nil {
+		t.Fatalf("connectionString() does not parse: %v", err)
+	}
+	password, _ := u.User.Password()
+	if u.Hostname() != "sqlmi-p-svc.arc.svc" || u.Port() != "1433" || u.User.Username() != "sa" || password != "p@ss;database=master" || u.RawQuery != "" {
+		t.Errorf("connectionString() = %q", db.connectionString())
+	}
+}
+
+func TestAlterDatabaseResults(t *testing.T) {
+	unsupported := mssql.Error{Number: 5069, Message: "ALTER DATABASE statement failed.", All: []mssql.Error{
+		{Number: 15048, Message: "Valid values of the database compatibility level are 100, 110, 120, 130, 140, or 150."},
+		{Number: 5069, Message: "ALTER DATABASE statement failed."},
+	}}
+	server, done := newStandInServer("alter-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		switch {
+		case strings.Contains(query, "COMPATIBILITY_LEVEL"):
+			return nil, unsupported
+		case strings.Contains(query, "recovery_fork_guid"):
+			return &standInRows{columns: []string{"recovery_fork_guid"}, values: [][]driver.Value{{"0f5a2b7e-4d1c-4e0a-9a53-2d7f5d6c8b11"}}}, nil
+		}
+		return nil, nil
+	})
+	defer done()
+
+	level, forced, snapshot := 160, "forced", true
+	params := &DatabaseParams{CompatibilityLevel: &level, Parameterization: &forced, AllowSnapshotIsolation: &snapshot}
+	id, results, err := NewMSSql("alter-server", "sa", "secret", 1433).CreateDatabase(context.Background(), "sales", params)
+	if err == nil {
+		t.Fatal("CreateDatabase() error = nil, want the compatibility level failure")
+	}
+	if classified := ClassifyError(err); classified.Number != 15048 || !classified.Permanent() {
+		t.Errorf("ClassifyError(%v) = %+v, want the permanent sql error 15048", err, classified)
+	}
+	if id == nil || *id != "0f5a2b7e-4d1c-4e0a-9a53-2d7f5d6c8b11" {
+		t.Errorf("CreateDatabase() id = %v, want the id of the created database", id)
+	}
+	var ddl int
+	for _, stmt := range server.Statements() {
+		if strings.HasPrefix(stmt, "ALTER DATABASE") {
+			ddl++
+		}
+	}
+	if ddl != 3 {
+		t.Errorf("CreateDatabase() ran %d alters, want all 3 after one failed", ddl)
+	}
+
+	want := map[string]bool{"parameterization": true, "allowSnapshotIsolation": true, "compatibilityLevel": false}
+	if len(results) != len(want) {
+		t.Fatalf("CreateDatabase() results = %+v, want one per setting", results)
+	}
+	for _, result := range results {
+		applied, ok := want[result.Field]
+		if !ok {
+			t.Errorf("unexpected result for %s", result.Field)
+			continue
+		}
+		if applied != (result.Err == nil) {
+			t.Errorf("%s applied = %v, want %v", result.Field, result.Err == nil, applied)
+		}
+		if result.Field == "compatibilityLevel" && (result.Value != "160" || ClassifyError(result.Err).Number != 15048) {
+			t.Errorf("compatibilityLevel result = %+v, want 160 failing with sql error 15048", result)
+		}
+	}
+
+	results, err = NewMSSql("alter-server", "sa", "secret", 1433).AlterDatabase(context.Background(), "sales", &DatabaseParams{Parameterization: &forced})
+	if err != nil || len(results) != 1 || results[0].Err != nil || results[0].Value != "forced" {
+		t.Errorf("AlterDatabase() = %+v, %v, want parameterization applied", results, err)
+	}
+}
+
+func TestPermissionSyncNeeded(t *testing.T) {
+	respond := func(query string, args []driver.NamedValue) (*standInRows, error) {
+		switch {
+		case strings.Contains(query, "sys.database_role_members"):
+			return &standInRows{columns: []string{"role", "member"}, values: [][]driver.Value{
+				{"readers", "app"},
+				{"readers", "reporting"},
+				{"db_datareader", "etl"},
+				{"db_datareader", "legacy"},
+			}}, nil
+		case strings.Contains(query, "sys.database_permissions"):
+			return &standInRows{columns: []string{"principal", "state", "permission", "class", "securable"}, values: [][]driver.Value{
+				{"app", "GRANT", "CONNECT", "DATABASE", ""},
+				{"app", "GRANT", "SELECT", "SCHEMA", "sales"},
+				{"app", "GRANT", "DELETE", "OBJECT", "sales.orders"},
+				{"etl", "DENY", "ALTER", "DATABASE", ""},
+				{"unmanaged", "GRANT", "CONTROL", "DATABASE", ""},
+			}}, nil
+		case strings.Contains(query, "[type] = 'R'"):
+			return &standInRows{columns: []string{"name"}, values: [][]driver.Value{{"readers"}, {"db_datareader"}}}, nil
+		}
+		return nil, nil
+	}
+	inSync := &PermissionConfig{
+		DatabaseName:       "sales",
+		Roles:              []string{"readers"},
+		Memberships:        []RoleMembership{{Role: "readers", Member: "app"}},
+		ManagedMemberships: []RoleMembership{{Role: "readers", Member: "app"}},
+		Principals:         []string{"app", "etl"},
+		Permissions: []Permission{
+			{Principal: "app", State: "GRANT", Permission: "SELECT", Class: "SCHEMA", Securable: "sales"},
+			{Principal: "app", State: "GRANT", Permission: "DELETE", Class: "OBJECT", Securable: "sales.orders"},
+			{Principal: "etl", State: "DENY", Permission: "ALTER", Class: "DATABASE"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		params *PermissionConfig
+		want   []string
+	}{
+		{
+			name:   "in sync",
+			params: inSync,
+		},
+		{
+			name: "drifted",
+			params: &PermissionConfig{
+				DatabaseName: "sales",
+				Roles:        []string{"readers", "writers"},
+				Memberships: []RoleMembership{
+					{Role: "readers", Member: "app"},
+					{Role: "writers", Member: "etl"},
+				},
+				// reporting was added by someone else and is kept, legacy was granted by the controller before
+				ManagedMemberships: []RoleMembership{
+					{Role: "readers", Member: "app"},
+					{Role: "db_datareader", Member: "legacy"},
+				},
+				Principals: []string{"app", "etl"},
+				Permissions: []Permission{
+					{Principal: "app", State: "GRANT", Permission: "SELECT", Class: "SCHEMA", Securable: "sales"},
+					{Principal: "etl", State: "GRANT", Permission: "ALTER", Class: "DATABASE"},
+				},
+			},
+			want: []string{
+				"CREATE ROLE [writers];",
+				"ALTER ROLE [db_datareader] DROP MEMBER [legacy];",
+				"ALTER ROLE [writers] ADD MEMBER [etl];",
+				"REVOKE DELETE ON OBJECT::[sales].[orders] FROM [app];",
+				"GRANT ALTER TO [etl];",
+			},
+		},
+		{
+			name: "names in another case",
+			params: &PermissionConfig{
+				DatabaseName:       "sales",
+				Roles:              []string{"readers"},
+				Memberships:        []RoleMembership{{Role: "readers", Member: "app"}},
+				ManagedMemberships: []RoleMembership{{Role: "readers", Member: "app"}},
+				Principals:         []string{"APP", "etl"},
+				Permissions: []Permission{
+					{Principal: "APP", State: "GRANT", Permission: "SELECT", Class: "SCHEMA", Securable: "Sales"},
+					{Principal: "APP", State: "GRANT", Permission: "DELETE", Class: "OBJECT", Securable: "Sales.Orders"},
+					{Principal: "etl", State: "DENY", Permission: "ALTER", Class: "DATABASE"},
+				},
+			},
+		},
+		{
+			name: "membership granted by others",
+			params: &PermissionConfig{
+				DatabaseName: "sales",
+				Roles:        []string{"readers"},
+				Principals:   []string{},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, done := newStandInServer("permission-server", respond)
+			defer done()
+
+			syncResponse, err := NewMSSql("permission-server", "sa", "secret", 1433).PermissionSyncNeeded(context.Background(), tt.params)
+			if err != nil {
+				t.Fatalf("PermissionSyncNeeded() error = %v", err)
+			}
+			if tt.want == nil {
+				if syncResponse != nil {
+					t.Errorf("PermissionSyncNeeded() = %q, want nothing to sync", syncResponse.Statements())
+				}
+				return
+			}
+			if syncResponse == nil {
+				t.Fatalf("PermissionSyncNeeded() = nil, want %q", tt.want)
+			}
+			if got := syncResponse.Statements(); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("PermissionSyncNeeded() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateLogin(t *testing.T) {
+	server, done := newStandInServer("login-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		if strings.HasPrefix(query, "SELECT CONVERT(varchar(172), [sid], 1) FROM sys.sql_logins") {
+			return &standInRows{columns: []string{"sid"}, values: [][]driver.Value{{"0x5B2A"}}}, nil
+		}
+		return nil, nil
+	})
+	defer done()
+	db := NewMSSql("login-server", "sa", "secret", 1433)
+
+	password, database, disabled, off := "it's secret", "sales", true, false
+	sid, err := db.CreateLogin(context.Background(), "app]", &LoginParams{
+		Password:        &password,
+		DefaultDatabase: &database,
+		CheckPolicy:     &off,
+		Disabled:        &disabled,
+	})
+	if err != nil {
+		t.Fatalf("CreateLogin() error = %v", err)
+	}
+	if SafeString(sid) != "0x5B2A" {
+		t.Errorf("CreateLogin() sid = %q, want 0x5B2A", SafeString(sid))
+	}
+	want := []string{
+		"CREATE LOGIN [app]]] WITH PASSWORD = N'it''s secret', DEFAULT_DATABASE = [sales], CHECK_POLICY = OFF;",
+		"ALTER LOGIN [app]]] DISABLE;",
+		"SELECT CONVERT(varchar(172), [sid], 1) FROM sys.sql_logins WHERE [name] = @p1",
+	}
+	if got := server.Statements(); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("CreateLogin() ran %q, want %q", got, want)
+	}
+
+	_, err = db.CreateLogin(context.Background(), "nopassword", &LoginParams{})
+	if got := ClassifyError(err); got == nil || got.Class != ErrorClassInvalidRequest {
+		t.Errorf("CreateLogin() without a password error = %v, want an invalid request", err)
+	}
+	if len(server.Statements()) != len(want) {
+		t.Errorf("CreateLogin() without a password ran %q", server.Statements()[len(want):])
+	}
+}
+
+func TestAlterLogin(t *testing.T) {
+	password, enabled, on := "rotated", false, true
+	tests := []struct {
+		name   string
+		params *LoginParams
+		want   []string
+	}{
+		{
+			name:   "rotated password",
+			params: &LoginParams{Password: &password},
+			want:   []string{"ALTER LOGIN [app] WITH PASSWORD = N'rotated';"},
+		},
+		{
+			name:   "enabled with checked expiration",
+			params: &LoginParams{CheckExpiration: &on, Disabled: &enabled},
+			want:   []string{"ALTER LOGIN [app] WITH CHECK_EXPIRATION = ON;", "ALTER LOGIN [app] ENABLE;"},
+		},
+		{
+			name:   "nothing to change",
+			params: &LoginParams{},
+			want:   []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, done := newStandInServer("alter-login-server", nil)
+			defer done()
+
+			if err := NewMSSql("alter-login-server", "sa", "secret", 1433).AlterLogin(context.Background(), "app", tt.params); err != nil {
+				t.Fatalf("AlterLogin() error = %v", err)
+			}
+			if got := server.Statements(); strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("AlterLogin() ran %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindLoginSID(t *testing.T) {
+	_, done := newStandInServer("adopt-login-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		if args[0].Value == "existing" {
+			return &standInRows{columns: []string{"sid"}, values: [][]driver.Value{{"0x5B2A"}}}, nil
+		}
+		return &standInRows{columns: []string{"sid"}}, nil
+	})
+	defer done()
+	db := NewMSSql("adopt-login-server", "sa", "secret", 1433)
+
+	// a login found on the server before the controller created it is refused rather than adopted
+	sid, err := db.FindLoginSID(context.Background(), "existing")
+	if err != nil || SafeString(sid) != "0x5B2A" {
+		t.Errorf("FindLoginSID(existing) = %q, %v, want 0x5B2A", SafeString(sid), err)
+	}
+	sid, err = db.FindLoginSID(context.Background(), "missing")
+	if err != nil || sid != nil {
+		t.Errorf("FindLoginSID(missing) = %q, %v, want nil", SafeString(sid), err)
+	}
+}
+
+func TestLoginSyncNeeded(t *testing.T) {
+	respond := func(query string, args []driver.NamedValue) (*standInRows, error) {
+		switch {
+		case strings.Contains(query, "WHERE [sid] ="):
+			if args[0].Value == "0x5B2A" {
+				return &standInRows{columns: []string{"name"}, values: [][]driver.Value{{"app"}}}, nil
+			}
+			return &standInRows{columns: []string{"name"}}, nil
+		case strings.Contains(query, "[default_database_name]"):
+			return &standInRows{columns: []string{"default_database_name", "is_disabled", "is_policy_checked", "is_expiration_checked"},
+				values: [][]driver.Value{{"master", false, true, false}}}, nil
+		}
+		return nil, nil
+	}
+	on, off := true, false
+	sales := "sales"
+	tests := []struct {
+		name    string
+		params  *LoginConfig
+		want    *LoginParams
+		wantErr bool
+	}{
+		{
+			name:   "in sync",
+			params: &LoginConfig{LoginName: "app", SID: "0x5B2A", CheckPolicy: &on},
+		},
+		{
+			name:   "drifted",
+			params: &LoginConfig{LoginName: "app", SID: "0x5B2A", DefaultDatabase: "sales", CheckPolicy: &off, CheckExpiration: &off, Disabled: true},
+			want:   &LoginParams{DefaultDatabase: &sales, CheckPolicy: &off, Disabled: &on},
+		},
+		{
+			name:    "renamed",
+			params:  &LoginConfig{LoginName: "reporting", SID: "0x5B2A"},
+			wantErr: true,
+		},
+		{
+			name:    "dropped",
+			params:  &LoginConfig{LoginName: "app", SID: "0x7C1D"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, done := newStandInServer("sync-login-server", respond)
+			defer done()
+
+			got, err := NewMSSql("sync-login-server", "sa", "secret", 1433).LoginSyncNeeded(context.Background(), tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoginSyncNeeded() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoginSyncNeeded() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
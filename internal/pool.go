@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// PoolOptions bound the connections kept open to every instance and login
+type PoolOptions struct {
+	// MaxOpenConns the connections open at once to an instance with one login
+	MaxOpenConns int
+	// MaxIdleConns the connections kept open between statements
+	MaxIdleConns int
+	// ConnMaxIdleTime closes a connection left unused for this long
+	ConnMaxIdleTime time.Duration
+	// ConnMaxLifetime reopens a connection after this long so endpoint changes are picked up
+	ConnMaxLifetime time.Duration
+	// IdleTimeout closes the whole pool of an instance and login nobody asked for in this long
+	IdleTimeout time.Duration
+	// Timeouts bound the calls of the providers handed out by the pool
+	Timeouts Timeouts
+}
+
+// DefaultPoolOptions the bounds used when the operator is not told otherwise
+func DefaultPoolOptions() PoolOptions {
+	return PoolOptions{
+		MaxOpenConns:    10,
+		MaxIdleConns:    2,
+		ConnMaxIdleTime: 5 * time.Minute,
+		ConnMaxLifetime: 30 * time.Minute,
+		IdleTimeout:     30 * time.Minute,
+		Timeouts:        DefaultTimeouts(),
+	}
+}
+
+type poolKey struct {
+	server string
+	port   int
+	user   string
+}
+
+type pooledDB struct {
+	db       *sql.DB
+	password [sha256.Size]byte
+	lastUsed time.Time
+	// users the calls holding the pool, a retired pool closes once the last of them releases it
+	users   int
+	retired bool
+}
+
+// Pool shares one connection pool per instance endpoint and login between the providers handed out by it.
+// Asking for a login with a password other than the one its pool was opened with retires that pool, so a rotated
+// credential is never used past the reconcile that reads it, while the calls still holding it finish before it
+// closes.  Pool is safe for concurrent use.
+type Pool struct {
+	options PoolOptions
+
+	mu  sync.Mutex
+	dbs map[poolKey]*pooledDB
+	now func() time.Time
+}
+
+// NewPool creates an empty pool, connections are opened on first use
+func NewPool(options PoolOptions) *Pool {
+	return &Pool{
+		options: options,
+		dbs:     map[poolKey]*pooledDB{},
+		now:     time.Now,
+	}
+}
+
+// Provider returns a provider for the instance reached at server and port with the login user and password
+func (p *Pool) Provider(server, user, password string, port int) *MSSql {
+	return &MSSql{
+		Server:   server,
+		Port:     port,
+		User:     user,
+		Password: password,
+		pool:     p,
+	}
+}
+
+// get returns the connection pool for the endpoint and login of the provider, opening it when needed, along with
+// the func releasing it once the caller is done with it
+func (p *Pool) get(db *MSSql) (*sql.DB, func(), error) {
+	key := poolKey{server: db.Server, port: db.Port, user: db.User}
+	password := sha256.Sum256([]byte(db.Password))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.evictIdle(now)
+	if entry, ok := p.dbs[key]; ok {
+		if entry.password == password {
+			return entry.db, p.acquire(entry, now), nil
+		}
+		// the credential rotated, calls still holding the old pool finish before it closes
+		delete(p.dbs, key)
+		p.retire(entry)
+	}
+
+	sqlDB, err := sql.Open(driverName, db.connectionString())
+	if err != nil {
+		return nil, nil, err
+	}
+	sqlDB.SetMaxOpenConns(p.options.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(p.options.MaxIdleConns)
+	sqlDB.SetConnMaxIdleTime(p.options.ConnMaxIdleTime)
+	sqlDB.SetConnMaxLifetime(p.options.ConnMaxLifetime)
+	entry := &pooledDB{db: sqlDB, password: password}
+	p.dbs[key] = entry
+	return sqlDB, p.acquire(entry, now), nil
+}
+
+// acquire counts a call holding the pool and returns the func releasing it, the caller holds the lock
+func (p *Pool) acquire(entry *pooledDB, now time.Time) func() {
+	entry.users++
+	entry.lastUsed = now
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			entry.users--
+			entry.lastUsed = p.now()
+			if entry.retired && entry.users == 0 {
+				entry.db.Close()
+			}
+		})
+	}
+}
+
+// retire closes a pool removed from the map once no call holds it, the caller holds the lock
+func (p *Pool) retire(entry *pooledDB) error {
+	entry.retired = true
+	if entry.users == 0 {
+		return entry.db.Close()
+	}
+	return nil
+}
+
+// evictIdle closes the pools nobody holds and nobody asked for within the idle timeout, the caller holds the lock
+func (p *Pool) evictIdle(now time.Time) {
+	if p.options.IdleTimeout <= 0 {
+		return
+	}
+	for key, entry := range p.dbs {
+		if entry.users == 0 && now.Sub(entry.lastUsed) > p.options.IdleTimeout {
+			delete(p.dbs, key)
+			p.retire(entry)
+		}
+	}
+}
+
+// Close closes every pool, the ones still held once their calls return, providers handed out before keep working
+// by opening new ones
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var err error
+	for key, entry := range p.dbs {
+		delete(p.dbs, key)
+		if cerr := p.retire(entry); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolSharesConnections(t *testing.T) {
+	_, done := newStandInServer("pool-server", nil)
+	defer done()
+
+	options := DefaultPoolOptions()
+	options.MaxOpenConns = 4
+	pool := NewPool(options)
+	defer pool.Close()
+
+	first, release, err := pool.Provider("pool-server", "app", "secret", 1433).connection()
+	if err != nil {
+		t.Fatalf("connection() error = %v", err)
+	}
+	defer release()
+	second, release, _ := pool.Provider("pool-server", "app", "secret", 1433).connection()
+	defer release()
+	if first != second {
+		t.Error("providers for the same instance and login do not share a pool")
+	}
+	other, release, _ := pool.Provider("pool-server", "reporting", "secret", 1433).connection()
+	defer release()
+	if other == first {
+		t.Error("providers for different logins share a pool")
+	}
+	if got := first.Stats().MaxOpenConnections; got != 4 {
+		t.Errorf("MaxOpenConnections = %d, want 4", got)
+	}
+}
+
+func TestPoolRotatesCredentials(t *testing.T) {
+	_, done := newStandInServer("rotation-server", nil)
+	defer done()
+
+	pool := NewPool(DefaultPoolOptions())
+	defer pool.Close()
+
+	old, releaseOld, _ := pool.Provider("rotation-server", "app", "first", 1433).connection()
+	rotated, release, err := pool.Provider("rotation-server", "app", "second", 1433).connection()
+	if err != nil {
+		t.Fatalf("connection() error = %v", err)
+	}
+	defer release()
+	if rotated == old {
+		t.Fatal("a rotated password reused the pool of the old one")
+	}
+	if err := old.Ping(); err != nil {
+		t.Errorf("the pool of the old password closed while a call still holds it: %v", err)
+	}
+	releaseOld()
+	if err := old.Ping(); err == nil {
+		t.Error("the pool of the old password is still open once released")
+	}
+	if err := rotated.Ping(); err != nil {
+		t.Errorf("the pool of the new password is not usable: %v", err)
+	}
+}
+
+func TestPoolEvictsIdle(t *testing.T) {
+	_, done := newStandInServer("idle-server", nil)
+	defer done()
+
+	options := DefaultPoolOptions()
+	options.IdleTimeout = time.Minute
+	pool := NewPool(options)
+	defer pool.Close()
+	now := time.Date(2021, 7, 1, 9, 0, 0, 0, time.UTC)
+	pool.now = func() time.Time { return now }
+
+	idle, release, _ := pool.Provider("idle-server", "app", "secret", 1433).connection()
+	release()
+	held, releaseHeld, _ := pool.Provider("idle-server", "restore", "secret", 1433).connection()
+	now = now.Add(30 * time.Second)
+	busy, release, _ := pool.Provider("idle-server", "busy", "secret", 1433).connection()
+	release()
+	now = now.Add(45 * time.Second)
+	again, release, _ := pool.Provider("idle-server", "busy", "secret", 1433).connection()
+	release()
+	if again != busy {
+		t.Error("a pool used within the idle timeout was replaced")
+	}
+	if err := idle.Ping(); err == nil {
+		t.Error("a pool unused past the idle timeout is still open")
+	}
+	if err := held.Ping(); err != nil {
+		t.Errorf("a pool held past the idle timeout was closed: %v", err)
+	}
+	if len(pool.dbs) != 2 {
+		t.Errorf("pool holds %d connection pools, want 2", len(pool.dbs))
+	}
+
+	// released, the held pool is idle from then on
+	releaseHeld()
+	now = now.Add(2 * time.Minute)
+	_, release, _ = pool.Provider("idle-server", "busy", "secret", 1433).connection()
+	release()
+	if err := held.Ping(); err == nil {
+		t.Error("a released pool unused past the idle timeout is still open")
+	}
+}
+
+func TestPoolConcurrentUse(t *testing.T) {
+	server, done := newStandInServer("concurrent-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		return &standInRows{columns: []string{"recovery_fork_guid"}, values: [][]driver.Value{{"0f5a2b7e-4d1c-4e0a-9a53-2d7f5d6c8b11"}}}, nil
+	})
+	defer done()
+
+	pool := NewPool(DefaultPoolOptions())
+	defer pool.Close()
+	shared := pool.Provider("concurrent-server", "app", "secret", 1433)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := shared.FindDatabaseID(context.Background(), "sales")
+			errs <- err
+		}()
+		go func(i int) {
+			defer wg.Done()
+			// a provider built per reconcile, half of them with a rotated password
+			password := "secret"
+			if i%2 == 1 {
+				password = "rotated"
+			}
+			_, err := pool.Provider("concurrent-server", "reporting", password, 1433).FindDatabaseName(context.Background(), "0f5a2b7e-4d1c-4e0a-9a53-2d7f5d6c8b11")
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		// a rotation racing a lookup leaves the pool it holds open until it returns
+		if err != nil {
+			t.Errorf("concurrent lookup error = %v", err)
+		}
+	}
+	if len(server.Statements()) == 0 {
+		t.Error("no statement reached the server")
+	}
+}
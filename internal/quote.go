@@ -0,0 +1,13 @@
+package internal
+
+import "strings"
+
+// QuoteName delimits a sql server identifier the same way QUOTENAME does
+func QuoteName(name string) string {
+	return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+}
+
+// QuoteString returns a unicode string literal safe to embed in a statement
+func QuoteString(value string) string {
+	return "N'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
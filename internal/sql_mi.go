@@ -1,89 +1,86 @@
+// the sql managed instance types are read from the arc data controller api, no crd is generated for them
+// +kubebuilder:skip
+
 package internal
 
-import "time"
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/scheme"
+)
+
+var (
+	// SQLManagedInstanceGroupVersion the api of the arc data services sql managed instance
+	SQLManagedInstanceGroupVersion = schema.GroupVersion{Group: "sql.arcdata.microsoft.com", Version: "v1"}
+
+	sqlManagedInstanceSchemeBuilder = &scheme.Builder{GroupVersion: SQLManagedInstanceGroupVersion}
+
+	// AddToScheme adds the sql managed instance types to the scheme
+	AddToScheme = sqlManagedInstanceSchemeBuilder.AddToScheme
+)
+
+// LoginRef the secret holding the admin login of the instance
+type LoginRef struct {
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+}
 
+// SQLManagedInstanceService a service exposing the instance
+type SQLManagedInstanceService struct {
+	Type string `json:"type,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// SQLManagedInstanceServices the services exposing the instance
+type SQLManagedInstanceServices struct {
+	Primary SQLManagedInstanceService `json:"primary,omitempty"`
+}
+
+// SQLManagedInstanceSpec the parts of the instance spec the operator reads
+type SQLManagedInstanceSpec struct {
+	Dev         bool                       `json:"dev,omitempty"`
+	LicenseType string                     `json:"licenseType,omitempty"`
+	LoginRef    LoginRef                   `json:"loginRef,omitempty"`
+	Replicas    int                        `json:"replicas,omitempty"`
+	Services    SQLManagedInstanceServices `json:"services,omitempty"`
+	Tier        string                     `json:"tier,omitempty"`
+}
+
+// SQLManagedInstanceStatus the observed state of the instance
+type SQLManagedInstanceStatus struct {
+	AGStatus           string `json:"AGStatus,omitempty"`
+	LogSearchDashboard string `json:"logSearchDashboard,omitempty"`
+	MetricsDashboard   string `json:"metricsDashboard,omitempty"`
+	ObservedGeneration int64  `json:"observedGeneration,omitempty"`
+	PrimaryEndpoint    string `json:"primaryEndpoint,omitempty"`
+	ReadyReplicas      string `json:"readyReplicas,omitempty"`
+	SecondaryEndpoint  string `json:"secondaryEndpoint,omitempty"`
+	State              string `json:"state,omitempty"`
+}
+
+// +kubebuilder:object:generate=true
+// +kubebuilder:object:root=true
+
+// SQLManagedInstance an arc data services sql managed instance, owned by the arc data controller
 type SQLManagedInstance struct {
-	APIVersion string `json:"apiVersion"`
-	Kind       string `json:"kind"`
-	Metadata   struct {
-		Annotations struct {
-			ManagementAzureComAPIVersion     string `json:"management.azure.com/apiVersion"`
-			ManagementAzureComCorrelationID  string `json:"management.azure.com/correlationId"`
-			ManagementAzureComCustomLocation string `json:"management.azure.com/customLocation"`
-			ManagementAzureComLocation       string `json:"management.azure.com/location"`
-			ManagementAzureComOperationID    string `json:"management.azure.com/operationId"`
-			ManagementAzureComResourceID     string `json:"management.azure.com/resourceId"`
-			ManagementAzureComTenantID       string `json:"management.azure.com/tenantId"`
-			Traceparent                      string `json:"traceparent"`
-		} `json:"annotations"`
-		CreationTimestamp time.Time `json:"creationTimestamp"`
-		Generation        int       `json:"generation"`
-		Name              string    `json:"name"`
-	} `json:"metadata"`
-	Spec struct {
-		Dev         bool   `json:"dev"`
-		LicenseType string `json:"licenseType"`
-		LoginRef    struct {
-			Kind      string `json:"kind"`
-			Name      string `json:"name"`
-			Namespace string `json:"namespace"`
-		} `json:"loginRef"`
-		Replicas   int `json:"replicas"`
-		Scheduling struct {
-			Default struct {
-				Resources struct {
-					Limits struct {
-						CPU    string `json:"cpu"`
-						Memory string `json:"memory"`
-					} `json:"limits"`
-					Requests struct {
-						CPU    string `json:"cpu"`
-						Memory string `json:"memory"`
-					} `json:"requests"`
-				} `json:"resources"`
-			} `json:"default"`
-		} `json:"scheduling"`
-		Services struct {
-			Primary struct {
-				Type string `json:"type"`
-			} `json:"primary"`
-		} `json:"services"`
-		Storage struct {
-			Backups struct {
-				Volumes []struct {
-					ClassName string `json:"className"`
-					Size      string `json:"size"`
-				} `json:"volumes"`
-			} `json:"backups"`
-			Data struct {
-				Volumes []struct {
-					ClassName string `json:"className"`
-					Size      string `json:"size"`
-				} `json:"volumes"`
-			} `json:"data"`
-			Datalogs struct {
-				Volumes []struct {
-					ClassName string `json:"className"`
-					Size      string `json:"size"`
-				} `json:"volumes"`
-			} `json:"datalogs"`
-			Logs struct {
-				Volumes []struct {
-					ClassName string `json:"className"`
-					Size      string `json:"size"`
-				} `json:"volumes"`
-			} `json:"logs"`
-		} `json:"storage"`
-		Tier string `json:"tier"`
-	} `json:"spec"`
-	Status struct {
-		AGStatus           string `json:"AGStatus"`
-		LogSearchDashboard string `json:"logSearchDashboard"`
-		MetricsDashboard   string `json:"metricsDashboard"`
-		ObservedGeneration int    `json:"observedGeneration"`
-		PrimaryEndpoint    string `json:"primaryEndpoint"`
-		ReadyReplicas      string `json:"readyReplicas"`
-		SecondaryEndpoint  string `json:"secondaryEndpoint"`
-		State              string `json:"state"`
-	} `json:"status"`
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SQLManagedInstanceSpec   `json:"spec,omitempty"`
+	Status SQLManagedInstanceStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:generate=true
+// +kubebuilder:object:root=true
+
+// SQLManagedInstanceList contains a list of SQLManagedInstance
+type SQLManagedInstanceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SQLManagedInstance `json:"items"`
+}
+
+func init() {
+	sqlManagedInstanceSchemeBuilder.Register(&SQLManagedInstance{}, &SQLManagedInstanceList{})
 }
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+// ErrorClass groups errors by what retrying the call achieves
+type ErrorClass string
+
+const (
+	// ErrorClassUnknown errors nothing is known about, they are retried
+	ErrorClassUnknown ErrorClass = "Unknown"
+	// ErrorClassTimeout the instance did not answer in time
+	ErrorClassTimeout ErrorClass = "Timeout"
+	// ErrorClassTransient the connection dropped or the instance is busy, failing over or starting
+	ErrorClassTransient ErrorClass = "Transient"
+	// ErrorClassDeadlock the statement lost a deadlock or waited too long for a lock
+	ErrorClassDeadlock ErrorClass = "Deadlock"
+	// ErrorClassDatabaseInUse other sessions keep the database from being changed or dropped
+	ErrorClassDatabaseInUse ErrorClass = "DatabaseInUse"
+	// ErrorClassLoginFailed the instance refused the login the provider connects with
+	ErrorClassLoginFailed ErrorClass = "LoginFailed"
+	// ErrorClassPermissionDenied the login lacks a permission the statement needs
+	ErrorClassPermissionDenied ErrorClass = "PermissionDenied"
+	// ErrorClassObjectExists the object to create is already on the instance
+	ErrorClassObjectExists ErrorClass = "ObjectExists"
+	// ErrorClassInvalidRequest the request cannot succeed as written, checked by the provider or refused by the instance
+	ErrorClassInvalidRequest ErrorClass = "InvalidRequest"
+	// ErrorClassObjectMissing the object created earlier was dropped outside of the operator
+	ErrorClassObjectMissing ErrorClass = "ObjectMissing"
+)
+
+// errorClasses the class of the sql server error numbers the provider knows
+var errorClasses = map[int32]ErrorClass{
+	// login failed, account disabled, locked out, password expired or must change
+	18452: ErrorClassLoginFailed, 18456: ErrorClassLoginFailed, 18470: ErrorClassLoginFailed,
+	18486: ErrorClassLoginFailed, 18487: ErrorClassLoginFailed, 18488: ErrorClassLoginFailed,
+	// permission denied on an object, a database or the server, principal not visible
+	229: ErrorClassPermissionDenied, 230: ErrorClassPermissionDenied, 262: ErrorClassPermissionDenied,
+	297: ErrorClassPermissionDenied, 300: ErrorClassPermissionDenied, 916: ErrorClassPermissionDenied,
+	15151: ErrorClassPermissionDenied, 15247: ErrorClassPermissionDenied,
+	// database in use, could not be locked exclusively, single user with a session connected
+	3702: ErrorClassDatabaseInUse, 5030: ErrorClassDatabaseInUse, 5061: ErrorClassDatabaseInUse, 5064: ErrorClassDatabaseInUse,
+	// deadlock victim, lock request timeout
+	1205: ErrorClassDeadlock, 1222: ErrorClassDeadlock,
+	// connectivity, database not available yet, throttling and failovers
+	20: ErrorClassTransient, 64: ErrorClassTransient, 121: ErrorClassTransient, 233: ErrorClassTransient,
+	4060: ErrorClassTransient, 4221: ErrorClassTransient, 10053: ErrorClassTransient, 10054: ErrorClassTransient,
+	10060: ErrorClassTransient, 10928: ErrorClassTransient, 10929: ErrorClassTransient, 40197: ErrorClassTransient,
+	40501: ErrorClassTransient, 40613: ErrorClassTransient, 49918: ErrorClassTransient, 49919: ErrorClassTransient,
+	49920: ErrorClassTransient,
+	// database, object, user or login already exists
+	1801: ErrorClassObjectExists, 2714: ErrorClassObjectExists, 15023: ErrorClassObjectExists, 15025: ErrorClassObjectExists,
+	// syntax errors, invalid collation, unsupported compatibility level, password policy
+	102: ErrorClassInvalidRequest, 105: ErrorClassInvalidRequest, 156: ErrorClassInvalidRequest,
+	448: ErrorClassInvalidRequest, 15048: ErrorClassInvalidRequest, 15118: ErrorClassInvalidRequest,
+}
+
+// SQLError an error of the provider along with its class, Number is the sql server error number for errors the
+// instance returned and zero for the ones raised before reaching it
+type SQLError struct {
+	Number  int32
+	Message string
+	Class   ErrorClass
+	err     error
+}
+
+func (e *SQLError) Error() string {
+	if e.Number != 0 {
+		return fmt.Sprintf("sql error %d: %s", e.Number, e.Message)
+	}
+	return e.Message
+}
+
+func (e *SQLError) Unwrap() error {
+	return e.err
+}
+
+// Permanent reports whether retrying the call fails the same way until the spec or the credentials change
+func (e *SQLError) Permanent() bool {
+	switch e.Class {
+	case ErrorClassLoginFailed, ErrorClassPermissionDenied, ErrorClassObjectExists, ErrorClassInvalidRequest,
+		ErrorClassObjectMissing:
+		return true
+	}
+	return false
+}
+
+// Errorf an error of class found before reaching the instance, such as an object the caller does not manage
+func Errorf(class ErrorClass, format string, args ...interface{}) error {
+	return &SQLError{Message: fmt.Sprintf(format, args...), Class: class}
+}
+
+// invalidRequest an error for a request the provider refuses to send to the instance
+func invalidRequest(format string, args ...interface{}) error {
+	return Errorf(ErrorClassInvalidRequest, format, args...)
+}
+
+// ClassifyError finds the class of err, looking through the errors it wraps, nil for a nil error
+func ClassifyError(err error) *SQLError {
+	if err == nil {
+		return nil
+	}
+	var sqlErr *SQLError
+	if errors.As(err, &sqlErr) {
+		return sqlErr
+	}
+	var serverErr mssql.Error
+	if errors.As(err, &serverErr) {
+		return classifyServerError(serverErr)
+	}
+
+	classified := &SQLError{Message: err.Error(), Class: ErrorClassUnknown, err: err}
+	var netErr net.Error
+	switch {
+	case IsTimeout(err):
+		classified.Class = ErrorClassTimeout
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, context.Canceled), errors.As(err, &netErr):
+		classified.Class = ErrorClassTransient
+	}
+	return classified
+}
+
+// classifyServerError picks the first error the instance returned that has a known class, a failing statement is
+// usually followed by a generic one such as `ALTER DATABASE statement failed`
+func classifyServerError(serverErr mssql.Error) *SQLError {
+	all := serverErr.All
+	if len(all) == 0 {
+		all = []mssql.Error{serverErr}
+	}
+	for _, e := range all {
+		if class, ok := errorClasses[e.Number]; ok {
+			return &SQLError{Number: e.Number, Message: e.Message, Class: class, err: serverErr}
+		}
+	}
+	return &SQLError{Number: all[0].Number, Message: all[0].Message, Class: ErrorClassUnknown, err: serverErr}
+}
+
+// ErrorMessage describes err for a status condition, naming the sql server error number it carries
+func ErrorMessage(err error) string {
+	classified := ClassifyError(err)
+	if classified == nil {
+		return ""
+	}
+	message := err.Error()
+	if classified.Number == 0 {
+		return message
+	}
+	if strings.Contains(message, classified.Message) {
+		return fmt.Sprintf("%s (sql error %d)", message, classified.Number)
+	}
+	return fmt.Sprintf("%s (sql error %d: %s)", message, classified.Number, classified.Message)
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+
+	mssql "github.com/denisenkom/go-mssqldb"
+)
+
+func TestClassifyError(t *testing.T) {
+	alterFailed := mssql.Error{Number: 5069, Message: "ALTER DATABASE statement failed.", All: []mssql.Error{
+		{Number: 15048, Message: "Valid values of the database compatibility level are 100, 110, 120, 130, 140, 150, or 160."},
+		{Number: 5069, Message: "ALTER DATABASE statement failed."},
+	}}
+	tests := []struct {
+		name      string
+		err       error
+		class     ErrorClass
+		number    int32
+		permanent bool
+	}{
+		{name: "login failed", err: mssql.Error{Number: 18456, Message: "Login failed for user 'app'."}, class: ErrorClassLoginFailed, number: 18456, permanent: true},
+		{name: "permission denied", err: mssql.Error{Number: 262, Message: "CREATE DATABASE permission denied in database 'master'."}, class: ErrorClassPermissionDenied, number: 262, permanent: true},
+		{name: "database in use", err: mssql.Error{Number: 3702, Message: "Cannot drop database \"sales\" because it is currently in use."}, class: ErrorClassDatabaseInUse, number: 3702},
+		{name: "wrapped deadlock", err: fmt.Errorf("failed to run: GRANT SELECT, error: %w", mssql.Error{Number: 1205, Message: "deadlock victim"}), class: ErrorClassDeadlock, number: 1205},
+		{name: "failover", err: mssql.Error{Number: 40613, Message: "Database 'sales' on server 'sqlmi' is not currently available."}, class: ErrorClassTransient, number: 40613},
+		{name: "object exists", err: mssql.Error{Number: 1801, Message: "Database 'sales' already exists."}, class: ErrorClassObjectExists, number: 1801, permanent: true},
+		{name: "cause before the generic error", err: alterFailed, class: ErrorClassInvalidRequest, number: 15048, permanent: true},
+		{name: "unknown server error", err: mssql.Error{Number: 50000, Message: "raised by a trigger"}, class: ErrorClassUnknown, number: 50000},
+		{name: "refused by the provider", err: invalidRequest("compatibility level: %d is not supported", 90), class: ErrorClassInvalidRequest, permanent: true},
+		{name: "raised by the caller", err: Errorf(ErrorClassObjectMissing, "user: %s no longer exists", "app"), class: ErrorClassObjectMissing, permanent: true},
+		{name: "timeout", err: fmt.Errorf("errors while running alter on database: sales: %w", context.DeadlineExceeded), class: ErrorClassTimeout},
+		{name: "broken connection", err: driver.ErrBadConn, class: ErrorClassTransient},
+		{name: "plain error", err: errors.New("database id: 42 does not exist"), class: ErrorClassUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClassifyError(tt.err)
+			if got.Class != tt.class || got.Number != tt.number || got.Permanent() != tt.permanent {
+				t.Errorf("ClassifyError() = %s %d permanent %v, want %s %d permanent %v", got.Class, got.Number, got.Permanent(), tt.class, tt.number, tt.permanent)
+			}
+		})
+	}
+	if ClassifyError(nil) != nil {
+		t.Error("ClassifyError(nil) is not nil")
+	}
+}
+
+func TestErrorMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "provider error", err: invalidRequest("invalid backup type: %q", "INCREMENTAL"), want: `invalid backup type: "INCREMENTAL"`},
+		{name: "server error", err: mssql.Error{Number: 18456, Message: "Login failed for user 'app'."}, want: "mssql: Login failed for user 'app'. (sql error 18456)"},
+		{name: "cause hidden by the generic error", err: mssql.Error{Number: 5069, Message: "ALTER DATABASE statement failed.", All: []mssql.Error{
+			{Number: 15048, Message: "Valid values of the database compatibility level are 100, 110, 120, 130, 140, 150, or 160."},
+			{Number: 5069, Message: "ALTER DATABASE statement failed."},
+		}}, want: "mssql: ALTER DATABASE statement failed. (sql error 15048: Valid values of the database compatibility level are 100, 110, 120, 130, 140, 150, or 160.)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorMessage(tt.err); got != tt.want {
+				t.Errorf("ErrorMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServerErrorsReachTheCaller(t *testing.T) {
+	_, done := newStandInServer("refusing-server", func(query string, args []driver.NamedValue) (*standInRows, error) {
+		return nil, mssql.Error{Number: 18456, Message: "Login failed for user 'app'."}
+	})
+	defer done()
+
+	_, err := NewMSSql("refusing-server", "app", "stale", 1433).FindLoginSID(context.Background(), "app")
+	if got := ClassifyError(err); got == nil || got.Class != ErrorClassLoginFailed || !got.Permanent() {
+		t.Errorf("ClassifyError(%v) = %+v, want a permanent login failure", err, got)
+	}
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Timeouts how long the calls of a provider wait for the instance before giving up, zero waits for as long as
+// the context of the call allows
+type Timeouts struct {
+	// Statement bounds lookups and the statements that change settings, logins, users and permissions
+	Statement time.Duration
+	// Create bounds creating a database and setting its options
+	Create time.Duration
+	// Backup bounds taking a backup
+	Backup time.Duration
+	// Restore bounds a restore running in the background
+	Restore time.Duration
+	// Migration bounds applying one migration script
+	Migration time.Duration
+}
+
+// DefaultTimeouts the timeouts used when the operator is not told otherwise
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Statement: 30 * time.Second,
+		Create:    5 * time.Minute,
+		Backup:    time.Hour,
+		Restore:   4 * time.Hour,
+		Migration: 10 * time.Minute,
+	}
+}
+
+// timeouts the timeouts of the pool the provider was handed out by
+func (db *MSSql) timeouts() Timeouts {
+	return db.pool.options.Timeouts
+}
+
+// withTimeout bounds ctx by timeout, a zero timeout leaves ctx as it is
+func (db *MSSql) withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// IsTimeout reports whether err comes from the instance not answering in time, as opposed to refusing the
+// statement
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
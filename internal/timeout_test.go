@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "no error", err: nil, want: false},
+		{name: "deadline", err: context.DeadlineExceeded, want: true},
+		{name: "wrapped deadline", err: fmt.Errorf("failed to run: ALTER DATABASE, error: %w", context.DeadlineExceeded), want: true},
+		{name: "dial timeout", err: &net.OpError{Op: "dial", Err: &net.DNSError{IsTimeout: true}}, want: true},
+		{name: "canceled", err: context.Canceled, want: false},
+		{name: "refused statement", err: errors.New("mssql: Database 'sales' already exists"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTimeout(tt.err); got != tt.want {
+				t.Errorf("IsTimeout(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatementTimeout(t *testing.T) {
+	server, done := newStandInServer("slow-server", nil)
+	defer done()
+	server.latency = func(string) time.Duration { return time.Minute }
+
+	options := DefaultPoolOptions()
+	options.Timeouts.Statement = 20 * time.Millisecond
+	pool := NewPool(options)
+	defer pool.Close()
+
+	start := time.Now()
+	_, err := pool.Provider("slow-server", "sa", "secret", 1433).FindDatabaseID(context.Background(), "sales")
+	if !IsTimeout(err) {
+		t.Fatalf("FindDatabaseID() error = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("FindDatabaseID() gave up after %s", elapsed)
+	}
+}
+
+func TestCreateTimeout(t *testing.T) {
+	server, done := newStandInServer("creating-server", nil)
+	defer done()
+	server.latency = func(query string) time.Duration {
+		if strings.HasPrefix(query, "CREATE DATABASE") {
+			return 100 * time.Millisecond
+		}
+		return 0
+	}
+
+	options := DefaultPoolOptions()
+	options.Timeouts.Statement = 20 * time.Millisecond
+	options.Timeouts.Create = 5 * time.Second
+	pool := NewPool(options)
+	defer pool.Close()
+
+	if _, _, err := pool.Provider("creating-server", "sa", "secret", 1433).CreateDatabase(context.Background(), "sales", &DatabaseParams{}); err != nil {
+		t.Fatalf("CreateDatabase() slower than the statement timeout error = %v", err)
+	}
+
+	options.Timeouts.Create = 20 * time.Millisecond
+	short := NewPool(options)
+	defer short.Close()
+	if _, _, err := short.Provider("creating-server", "sa", "secret", 1433).CreateDatabase(context.Background(), "sales", &DatabaseParams{}); !IsTimeout(err) {
+		t.Errorf("CreateDatabase() error = %v, want a timeout", err)
+	}
+}
+
+func TestCallerCancellation(t *testing.T) {
+	server, done := newStandInServer("hung-server", nil)
+	defer done()
+	server.latency = func(string) time.Duration { return time.Minute }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := NewMSSql("hung-server", "sa", "secret", 1433).FindLoginSID(ctx, "app")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("FindLoginSID() error = %v, want the cancellation of the caller", err)
+	}
+	if IsTimeout(err) {
+		t.Error("a canceled call is reported as a timeout")
+	}
+}
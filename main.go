@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"os"
 
@@ -30,9 +31,11 @@ import (
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 
 	sqlmiv1alpha1 "github.com/pplavetzki/arc-sql-mi/api/v1alpha1"
 	"github.com/pplavetzki/arc-sql-mi/controllers"
+	ms "github.com/pplavetzki/arc-sql-mi/internal"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,6 +48,7 @@ func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 
 	utilruntime.Must(sqlmiv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(ms.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -52,11 +56,42 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var allowInstanceAdminCredentials bool
+	var syncJobTemplatePath, syncImage, syncServiceAccount, syncClusterRole string
+	poolOptions := ms.DefaultPoolOptions()
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&allowInstanceAdminCredentials, "allow-instance-admin-credentials", false,
+		"Connect with the admin login of the sql managed instance to databases and restores that name no credentials secret, logins always use it.")
+	flag.StringVar(&syncJobTemplatePath, "sync-job-template", "",
+		"A yaml file with the settings of the sync CronJobs of the CronJob sync mode, in the form of the syncJobTemplate of a Database.")
+	flag.StringVar(&syncImage, "sync-image", "", "The image of the sync CronJobs, overrides the sync job template.")
+	flag.StringVar(&syncServiceAccount, "sync-service-account", "",
+		"The service account the sync CronJobs run as, overrides the sync job template.")
+	flag.StringVar(&syncClusterRole, "sync-cluster-role", controllers.DefaultSyncClusterRole,
+		"The cluster role the operator binds to the sync service account in the namespace of each Database, empty leaves creating the service account and binding to the cluster admin. "+
+			"The operator is only allowed to bind a role granting no more than its own permissions, or one its role grants bind on.")
+	flag.IntVar(&poolOptions.MaxOpenConns, "sql-max-open-conns", poolOptions.MaxOpenConns,
+		"The connections open at once to a sql managed instance with one login.")
+	flag.IntVar(&poolOptions.MaxIdleConns, "sql-max-idle-conns", poolOptions.MaxIdleConns,
+		"The connections to a sql managed instance with one login kept open between statements.")
+	flag.DurationVar(&poolOptions.ConnMaxIdleTime, "sql-conn-max-idle-time", poolOptions.ConnMaxIdleTime,
+		"How long a connection to a sql managed instance is kept open unused.")
+	flag.DurationVar(&poolOptions.IdleTimeout, "sql-pool-idle-timeout", poolOptions.IdleTimeout,
+		"How long the connection pool of a sql managed instance and login is kept once nothing uses it.")
+	flag.DurationVar(&poolOptions.Timeouts.Statement, "sql-statement-timeout", poolOptions.Timeouts.Statement,
+		"How long a lookup or a statement changing settings, logins, users or permissions waits for the sql managed instance.")
+	flag.DurationVar(&poolOptions.Timeouts.Create, "sql-create-timeout", poolOptions.Timeouts.Create,
+		"How long creating a database waits for the sql managed instance.")
+	flag.DurationVar(&poolOptions.Timeouts.Backup, "sql-backup-timeout", poolOptions.Timeouts.Backup,
+		"How long a backup waits for the sql managed instance.")
+	flag.DurationVar(&poolOptions.Timeouts.Restore, "sql-restore-timeout", poolOptions.Timeouts.Restore,
+		"How long a restore may run before it is canceled.")
+	flag.DurationVar(&poolOptions.Timeouts.Migration, "sql-migration-timeout", poolOptions.Timeouts.Migration,
+		"How long a migration script may run before it is rolled back.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -78,12 +113,106 @@ func main() {
 		os.Exit(1)
 	}
 
+	// one pool per instance and login, shared by every controller and closed when the manager stops
+	pool := ms.NewPool(poolOptions)
+	if err = mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		<-ctx.Done()
+		return pool.Close()
+	})); err != nil {
+		setupLog.Error(err, "unable to add the sql connection pool to the manager")
+		os.Exit(1)
+	}
+
+	syncJobTemplate, err := controllers.LoadSyncJobTemplate(syncJobTemplatePath)
+	if err != nil {
+		setupLog.Error(err, "unable to load the sync job template", "path", syncJobTemplatePath)
+		os.Exit(1)
+	}
+	if syncImage != "" {
+		syncJobTemplate.Image = syncImage
+	}
+	if syncServiceAccount != "" {
+		syncJobTemplate.ServiceAccountName = syncServiceAccount
+	}
+
 	if err = (&controllers.DatabaseReconciler{
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		Logger:                        ctrl.Log.WithName("controllers").WithName("database"),
+		Pool:                          pool,
+		Recorder:                      mgr.GetEventRecorderFor("database-controller"),
+		AllowInstanceAdminCredentials: allowInstanceAdminCredentials,
+		SyncJobTemplate:               syncJobTemplate,
+		SyncClusterRole:               syncClusterRole,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Database")
+		os.Exit(1)
+	}
+	if err = (&controllers.LoginReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
-		Logger: ctrl.Log.WithName("controllers").WithName("database"),
+		Logger: ctrl.Log.WithName("controllers").WithName("login"),
+		Pool:   pool,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "Database")
+		setupLog.Error(err, "unable to create controller", "controller", "Login")
+		os.Exit(1)
+	}
+	if err = (&controllers.DatabaseUserReconciler{
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		Logger:                        ctrl.Log.WithName("controllers").WithName("databaseuser"),
+		Pool:                          pool,
+		AllowInstanceAdminCredentials: allowInstanceAdminCredentials,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabaseUser")
+		os.Exit(1)
+	}
+	if err = (&controllers.DatabasePermissionReconciler{
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		Logger:                        ctrl.Log.WithName("controllers").WithName("databasepermission"),
+		Pool:                          pool,
+		AllowInstanceAdminCredentials: allowInstanceAdminCredentials,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "DatabasePermission")
+		os.Exit(1)
+	}
+	if err = (&controllers.BackupReconciler{
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		Logger:                        ctrl.Log.WithName("controllers").WithName("backup"),
+		Pool:                          pool,
+		AllowInstanceAdminCredentials: allowInstanceAdminCredentials,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Backup")
+		os.Exit(1)
+	}
+	if err = (&controllers.RestoreReconciler{
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		Logger:                        ctrl.Log.WithName("controllers").WithName("restore"),
+		Pool:                          pool,
+		AllowInstanceAdminCredentials: allowInstanceAdminCredentials,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Restore")
+		os.Exit(1)
+	}
+	if err = (&controllers.BackupPolicyReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+		Logger: ctrl.Log.WithName("controllers").WithName("backuppolicy"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "BackupPolicy")
+		os.Exit(1)
+	}
+	if err = (&controllers.MigrationReconciler{
+		Client:                        mgr.GetClient(),
+		Scheme:                        mgr.GetScheme(),
+		Logger:                        ctrl.Log.WithName("controllers").WithName("migration"),
+		Pool:                          pool,
+		AllowInstanceAdminCredentials: allowInstanceAdminCredentials,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Migration")
 		os.Exit(1)
 	}
 	if err = (&sqlmiv1alpha1.Database{}).SetupWebhookWithManager(mgr); err != nil {